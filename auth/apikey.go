@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tphan267/common/system"
+	"github.com/tphan267/common/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeySecretLength is the length of the secret following the prefix of API keys.
+	apiKeySecretLength = 32
+	// apiKeyLastUsedInterval bounds the writes tracking the last use of API keys.
+	apiKeyLastUsedInterval = time.Minute
+)
+
+// ApiKey is a stored API key. The key is "<prefix>.<secret>", only the prefix
+// and the SHA-256 hash of the secret are stored.
+type ApiKey struct {
+	ID         uint64     `json:"id" gorm:"primaryKey"`
+	Prefix     string     `json:"prefix" gorm:"type:varchar(8);uniqueIndex"`
+	SecretHash string     `json:"-" gorm:"type:varchar(64)"`
+	AccountID  uint64     `json:"accountId" gorm:"index"`
+	TenantID   uint64     `json:"tenantId,omitempty" gorm:"index"`
+	Name       string     `json:"name" gorm:"type:varchar(128)"`
+	Scopes     string     `json:"scopes" gorm:"type:varchar(512)"` // Space separated
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// ScopeList returns the scopes granted to the key.
+func (k *ApiKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// HasScope reports whether the scope is granted to the key.
+func (k *ApiKey) HasScope(scope string) bool {
+	return slices.Contains(k.ScopeList(), scope)
+}
+
+// GormApiKeyStore stores hashed API keys using GORM.
+type GormApiKeyStore struct {
+	db *gorm.DB
+}
+
+// NewGormApiKeyStore initializes a new GormApiKeyStore and migrates its table.
+func NewGormApiKeyStore(db *gorm.DB) (*GormApiKeyStore, error) {
+	if err := db.AutoMigrate(&ApiKey{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate api keys: %v", err)
+	}
+	return &GormApiKeyStore{db: db}, nil
+}
+
+// CreateApiKey generates a new API key for the account, requests authenticated
+// with it act on behalf of the account and its tenant. The returned key is the
+// only copy of the secret, it can't be retrieved later. A zero expiresIn
+// creates a key that never expires.
+func (s *GormApiKeyStore) CreateApiKey(owner *AuthTokenData, name string, scopes []string, expiresIn time.Duration) (string, *ApiKey, error) {
+	prefix, err := utils.GenerateID()
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := utils.GenerateRandomString(apiKeySecretLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	apiKey := &ApiKey{
+		Prefix:     prefix,
+		SecretHash: hashApiKeySecret(secret),
+		AccountID:  owner.ID,
+		TenantID:   owner.TenantID,
+		Name:       name,
+		Scopes:     strings.Join(scopes, " "),
+	}
+	if expiresIn > 0 {
+		expiresAt := time.Now().Add(expiresIn)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.Create(apiKey).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to save api key: %w", err)
+	}
+
+	return prefix + "." + secret, apiKey, nil
+}
+
+// ValidateApiKey looks up the key by prefix and checks its secret, expiry and
+// revocation. Errors wrap ErrMalformed, ErrInvalidToken, ErrTokenExpired or ErrRevoked,
+// or ErrAuthUnavailable when the key could not be retrieved.
+func (s *GormApiKeyStore) ValidateApiKey(key string) (*ApiKey, error) {
+	if !IsApiKey(key) {
+		return nil, fmt.Errorf("%w: invalid api key format", ErrMalformed)
+	}
+	prefix, secret, _ := strings.Cut(key, ".")
+
+	apiKey := &ApiKey{}
+	err := s.db.Where("prefix = ?", prefix).First(apiKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Compare anyway so unknown prefixes take as long as wrong secrets
+		subtle.ConstantTimeCompare([]byte(hashApiKeySecret(secret)), []byte(hashApiKeySecret("")))
+		return nil, fmt.Errorf("%w: unknown api key", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, authUnavailable(fmt.Errorf("failed to retrieve api key: %w", err))
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashApiKeySecret(secret)), []byte(apiKey.SecretHash)) != 1 {
+		return nil, fmt.Errorf("%w: invalid api key", ErrInvalidToken)
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: api key has been revoked", ErrRevoked)
+	}
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		return nil, fmt.Errorf("%w: api key has expired", ErrTokenExpired)
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedInterval {
+		apiKey.LastUsedAt = &now
+		if err := s.db.Model(apiKey).UpdateColumn("last_used_at", now).Error; err != nil {
+			system.Logger.Errorf("Error tracking api key usage: %v", err)
+		}
+	}
+
+	return apiKey, nil
+}
+
+// RevokeApiKey revokes the key with the given prefix.
+func (s *GormApiKeyStore) RevokeApiKey(prefix string) error {
+	result := s.db.Model(&ApiKey{}).
+		Where("prefix = ? AND revoked_at IS NULL", prefix).
+		UpdateColumn("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke api key: %w", result.Error)
+	}
+	return nil
+}
+
+// ListApiKeys returns the keys of the account, including expired and revoked ones.
+func (s *GormApiKeyStore) ListApiKeys(accountID uint64) ([]ApiKey, error) {
+	var apiKeys []ApiKey
+	if err := s.db.Where("account_id = ?", accountID).Order("id ASC").Find(&apiKeys).Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve api keys: %w", err)
+	}
+	return apiKeys, nil
+}
+
+// ApiKeyMiddleware authenticates API keys with the store, without calling AUTH_API.
+// The account only carries the IDs of the key owner and the scopes of the key,
+// the key is stored in ctx.Locals("apiKey"). Keys are looked up like
+// RemoteAPIKeyMiddleware unless extractTokens are given.
+func ApiKeyMiddleware(store *GormApiKeyStore, extractTokens ...string) fiber.Handler {
+	if len(extractTokens) == 0 {
+		extractTokens = []string{"header:Authorization,query:apikey"}
+	}
+
+	return func(ctx *fiber.Ctx) error {
+		key := ExtractToken(ctx, extractTokens...)
+		if key == "" {
+			return ErrorAuthResp(ctx, ErrMissingToken)
+		}
+
+		apiKey, err := store.ValidateApiKey(key)
+		if err != nil {
+			return ErrorAuthResp(ctx, err)
+		}
+
+		setAccountLocals(ctx, key, &AuthTokenData{
+			ID:       apiKey.AccountID,
+			TenantID: apiKey.TenantID,
+			Scopes:   apiKey.ScopeList(),
+		})
+		ctx.Locals("apiKey", apiKey)
+
+		return ctx.Next()
+	}
+}
+
+func hashApiKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
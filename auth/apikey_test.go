@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupApiKeyStore(t *testing.T) *GormApiKeyStore {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err, "Failed to open database")
+
+	store, err := NewGormApiKeyStore(db)
+	require.NoError(t, err, "Failed to initialize GormApiKeyStore")
+	return store
+}
+
+func TestGormApiKeyStore(t *testing.T) {
+	store := setupApiKeyStore(t)
+
+	key, apiKey, err := store.CreateApiKey(&AuthTokenData{ID: 5}, "ci", []string{"read", "write"}, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, IsApiKey(key), "Generated key should have the api key format")
+	assert.True(t, strings.HasPrefix(key, apiKey.Prefix+"."))
+	assert.NotContains(t, apiKey.SecretHash, key[9:], "The secret must not be stored")
+
+	validated, err := store.ValidateApiKey(key)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), validated.AccountID)
+	assert.True(t, validated.HasScope("write"))
+	assert.False(t, validated.HasScope("admin"))
+	require.NotNil(t, validated.LastUsedAt, "Last use should be tracked")
+
+	_, err = store.ValidateApiKey(apiKey.Prefix + ".wrongsecretwrongsecretwrongsecre")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = store.ValidateApiKey("unknown1.wrongsecretwrongsecretwrongsecre")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = store.ValidateApiKey("not-an-api-key")
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	require.NoError(t, store.RevokeApiKey(apiKey.Prefix))
+	_, err = store.ValidateApiKey(key)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	expiredKey, _, err := store.CreateApiKey(&AuthTokenData{ID: 5}, "expired", nil, time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = store.ValidateApiKey(expiredKey)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	apiKeys, err := store.ListApiKeys(5)
+	require.NoError(t, err)
+	assert.Len(t, apiKeys, 2)
+}
+
+func TestApiKeyMiddleware(t *testing.T) {
+	store := setupApiKeyStore(t)
+	key, _, err := store.CreateApiKey(&AuthTokenData{ID: 9, TenantID: 2}, "service", []string{"read"}, 0)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/data", ApiKeyMiddleware(store), func(c *fiber.Ctx) error {
+		assert.Equal(t, uint64(9), c.Locals("uiID"))
+		assert.Equal(t, uint64(2), c.Locals("tenantID"))
+		assert.True(t, c.Locals("apiKey").(*ApiKey).HasScope("read"))
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/data", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/data?apikey="+key, nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	tampered := []byte(key)
+	tampered[9] ^= 1
+	resp, err = app.Test(httptest.NewRequest("GET", "/data?apikey="+string(tampered), nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tphan267/common/api"
+	commonJWT "github.com/tphan267/common/jwt"
+)
+
+// Errors returned by ParseToken and the refresh token functions, use errors.Is
+// to tell them apart. The token errors of the jwt package are re-exported, so
+// callers don't need to import both packages.
+var (
+	// ErrMissingToken is returned when the request carries no token.
+	ErrMissingToken = errors.New("missing auth token")
+	// ErrInvalidToken is returned for authentic tokens that can't be used for
+	// the request, e.g. a refresh token used as access token.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrRevoked is returned for tokens revoked before their expiry.
+	ErrRevoked = errors.New("token has been revoked")
+	// ErrAuthUnavailable is returned when the auth API or a store backing the
+	// credentials could not validate them, e.g. during an outage. It is not an
+	// authentication failure.
+	ErrAuthUnavailable = errors.New("auth service unavailable")
+	// ErrSessionExpired is returned for unknown, expired or killed sessions.
+	ErrSessionExpired = errors.New("session has expired")
+
+	ErrMalformed        = commonJWT.ErrMalformed
+	ErrUnknownKey       = commonJWT.ErrUnknownKey
+	ErrTampered         = commonJWT.ErrTampered
+	ErrTokenExpired     = commonJWT.ErrTokenExpired
+	ErrTokenNotYetValid = commonJWT.ErrTokenNotYetValid
+	ErrInvalidClaims    = commonJWT.ErrInvalidClaims
+)
+
+// Error codes sent as the ApiError detail of authentication failures. Clients
+// should refresh the token on ErrorCodeTokenExpired and ErrorCodeUnknownKey,
+// and log in again on the others.
+const (
+	ErrorCodeMissingToken     = "missing_token"
+	ErrorCodeMalformedToken   = "malformed_token"
+	ErrorCodeUnknownKey       = "unknown_key"
+	ErrorCodeInvalidToken     = "invalid_token"
+	ErrorCodeTokenExpired     = "token_expired"
+	ErrorCodeTokenNotYetValid = "token_not_yet_valid"
+	ErrorCodeTokenRevoked     = "token_revoked"
+	ErrorCodeSessionExpired   = "session_expired"
+	ErrorCodeAuthUnavailable  = "auth_unavailable"
+)
+
+// authErrors maps the error codes to their error, in the order they are matched.
+var authErrors = []struct {
+	code        string
+	err         error
+	description string
+}{
+	{ErrorCodeMissingToken, ErrMissingToken, "Missing auth token"},
+	{ErrorCodeTokenRevoked, ErrRevoked, "The token has been revoked"},
+	{ErrorCodeTokenRevoked, ErrRefreshTokenReused, "The refresh token has already been used"},
+	{ErrorCodeTokenRevoked, ErrRefreshTokenFamilyRevoked, "The refresh token has been revoked"},
+	{ErrorCodeSessionExpired, ErrSessionExpired, "The session has expired"},
+	{ErrorCodeTokenExpired, ErrTokenExpired, "The token has expired"},
+	{ErrorCodeTokenNotYetValid, ErrTokenNotYetValid, "The token is not valid yet"},
+	{ErrorCodeUnknownKey, ErrUnknownKey, "The token was issued with an unknown key"},
+	{ErrorCodeMalformedToken, ErrMalformed, "The token is malformed"},
+}
+
+// AuthErrorCode returns the error code matching err, ErrorCodeInvalidToken
+// when it matches none of the known errors.
+func AuthErrorCode(err error) string {
+	code, _ := authErrorCode(err)
+	return code
+}
+
+func authErrorCode(err error) (string, string) {
+	for _, authErr := range authErrors {
+		if errors.Is(err, authErr.err) {
+			return authErr.code, authErr.description
+		}
+	}
+	return ErrorCodeInvalidToken, "The token is invalid"
+}
+
+// AuthErrorFromCode returns the error matching an error code, e.g. one received
+// from the auth API, ErrInvalidToken for unknown codes.
+func AuthErrorFromCode(code string) error {
+	for _, authErr := range authErrors {
+		if authErr.code == code {
+			return authErr.err
+		}
+	}
+	return ErrInvalidToken
+}
+
+// authUnavailable wraps a failure of the auth API or of a store with
+// ErrAuthUnavailable, so it isn't reported as an invalid credential.
+func authUnavailable(err error) error {
+	return fmt.Errorf("%w: %w", ErrAuthUnavailable, err)
+}
+
+// ErrorAuthResp responds 401 with the error code matching err as detail and
+// a WWW-Authenticate challenge as defined by RFC 6750. It responds 503 when
+// the auth API or a store is unavailable, so clients don't discard valid tokens.
+func ErrorAuthResp(c *fiber.Ctx, err error) error {
+	if errors.Is(err, ErrAuthUnavailable) {
+		return api.ErrorResp(c, api.ApiError{
+			Code:    fiber.StatusServiceUnavailable,
+			Message: "Auth service unavailable",
+			Detail:  ErrorCodeAuthUnavailable,
+		})
+	}
+
+	code, description := authErrorCode(err)
+
+	if code == ErrorCodeMissingToken {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer`)
+	} else {
+		c.Set(fiber.HeaderWWWAuthenticate, fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, description))
+	}
+
+	return api.ErrorResp(c, api.ApiError{
+		Code:    fiber.StatusUnauthorized,
+		Message: description,
+		Detail:  code,
+	})
+}
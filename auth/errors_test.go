@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tphan267/common/api"
+)
+
+func TestParseToken_TypedErrors(t *testing.T) {
+	InitRevocationStore(NewInMemoryRevocationStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	_, err := ParseToken(km, "")
+	assert.ErrorIs(t, err, ErrMissingToken)
+
+	_, err = ParseToken(km, "this.is.not.a.valid.token")
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	token, err := GenerateToken(km, &AuthTokenData{ID: 1}, time.Second)
+	require.NoError(t, err)
+	time.Sleep(2 * time.Second)
+	_, err = ParseToken(km, *token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	token, err = GenerateToken(km, &AuthTokenData{ID: 1})
+	require.NoError(t, err)
+	require.NoError(t, RevokeToken(km, *token))
+	_, err = ParseToken(km, *token)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	InitRefreshTokenStore(NewInMemoryRefreshTokenStore())
+	refreshToken, err := IssueRefreshToken(km, &AuthTokenData{ID: 2})
+	require.NoError(t, err)
+	_, err = ParseToken(km, refreshToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Tokens of another issuer are signed with a key unknown to km
+	other := setupKeyManager(t, 24*time.Hour)
+	defer other.Shutdown()
+	token, err = GenerateToken(other, &AuthTokenData{ID: 1})
+	require.NoError(t, err)
+	_, err = ParseToken(km, *token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestErrorAuthResp(t *testing.T) {
+	tests := []struct {
+		err       error
+		code      string
+		challenge string
+	}{
+		{ErrMissingToken, ErrorCodeMissingToken, `Bearer`},
+		{fmt.Errorf("failed to decrypt token: %w", ErrTokenExpired), ErrorCodeTokenExpired, `Bearer error="invalid_token", error_description="The token has expired"`},
+		{ErrTokenNotYetValid, ErrorCodeTokenNotYetValid, `Bearer error="invalid_token", error_description="The token is not valid yet"`},
+		{ErrUnknownKey, ErrorCodeUnknownKey, `Bearer error="invalid_token", error_description="The token was issued with an unknown key"`},
+		{ErrMalformed, ErrorCodeMalformedToken, `Bearer error="invalid_token", error_description="The token is malformed"`},
+		{ErrRevoked, ErrorCodeTokenRevoked, `Bearer error="invalid_token", error_description="The token has been revoked"`},
+		{ErrRefreshTokenReused, ErrorCodeTokenRevoked, `Bearer error="invalid_token", error_description="The refresh token has already been used"`},
+		{ErrTampered, ErrorCodeInvalidToken, `Bearer error="invalid_token", error_description="The token is invalid"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				return ErrorAuthResp(c, tt.err)
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			require.NoError(t, err)
+			assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+			assert.Equal(t, tt.challenge, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+
+			body, _ := io.ReadAll(resp.Body)
+			result := api.ApiResponse{}
+			require.NoError(t, json.Unmarshal(body, &result))
+			require.NotNil(t, result.Error)
+			assert.Equal(t, tt.code, result.Error.Detail)
+
+			// The code maps back to an error of the same code, e.g. for RemoteAccount
+			assert.Equal(t, tt.code, AuthErrorCode(AuthErrorFromCode(tt.code)))
+		})
+	}
+}
+
+type failingRevocationStore struct {
+	*InMemoryRevocationStore
+}
+
+func (s failingRevocationStore) IsRevoked(string, uint64, time.Time) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+type failingSessionStore struct {
+	*InMemorySessionStore
+}
+
+func (s failingSessionStore) GetSession(string) (*Session, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestErrorAuthResp_BackendErrors(t *testing.T) {
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	token, err := GenerateToken(km, &AuthTokenData{ID: 1})
+	require.NoError(t, err)
+	InitRevocationStore(failingRevocationStore{NewInMemoryRevocationStore()})
+	defer InitRevocationStore(NewInMemoryRevocationStore())
+	_, err = ParseToken(km, *token)
+	assert.ErrorIs(t, err, ErrAuthUnavailable, "Revocation store errors are not authentication failures")
+
+	apiKeys := setupApiKeyStore(t)
+	key, _, err := apiKeys.CreateApiKey(&AuthTokenData{ID: 9}, "service", nil, 0)
+	require.NoError(t, err)
+	require.NoError(t, apiKeys.db.Migrator().DropTable(&ApiKey{}))
+	_, err = apiKeys.ValidateApiKey(key)
+	assert.ErrorIs(t, err, ErrAuthUnavailable, "Database errors are not authentication failures")
+
+	sessions := NewSessionManager(failingSessionStore{NewInMemorySessionStore()})
+
+	app := fiber.New()
+	app.Get("/token", func(c *fiber.Ctx) error {
+		_, err := ParseToken(km, *token)
+		return ErrorAuthResp(c, err)
+	})
+	app.Get("/apikey", ApiKeyMiddleware(apiKeys), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Get("/session", sessions.Middleware(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for _, path := range []string{"/token", "/apikey?apikey=" + key, "/session"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", path, nil)
+			req.AddCookie(&http.Cookie{Name: "session_id", Value: "session"})
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+
+			body, _ := io.ReadAll(resp.Body)
+			result := api.ApiResponse{}
+			require.NoError(t, json.Unmarshal(body, &result))
+			require.NotNil(t, result.Error)
+			assert.Equal(t, ErrorCodeAuthUnavailable, result.Error.Detail)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tphan267/common/system"
+)
+
+const (
+	// IdentityTimestampHeader carries the unix time at which identity headers were signed.
+	IdentityTimestampHeader = "X-Identity-Timestamp"
+	// IdentitySignatureHeader carries the HMAC-SHA256 of the timestamp and the X-User-* headers.
+	IdentitySignatureHeader = "X-Identity-Signature"
+
+	identityHeaderPrefix  = "x-user-"
+	defaultIdentityMaxAge = 30 * time.Second
+)
+
+// ProxyAuthConfig configures ProxyAuthMiddleware.
+type ProxyAuthConfig struct {
+	// Secret verifies the identity signature, defaults to the PROXY_AUTH_SECRET env.
+	Secret []byte
+	// MaxAge bounds the clock difference with the signing gateway, defaults to 30s.
+	MaxAge time.Duration
+	// TrustedNetworks lists the IPs or CIDRs of peers allowed to send unsigned
+	// identity headers, defaults to the comma separated PROXY_TRUSTED_NETWORKS env.
+	TrustedNetworks []string
+}
+
+// IdentityHeaders returns the X-User-* headers describing the account, signed
+// with secret, for gateways in front of services using ProxyAuthMiddleware.
+func IdentityHeaders(act *AuthTokenData, secret []byte) map[string]string {
+	headers := map[string]string{
+		"X-User-Id":       strconv.FormatUint(act.ID, 10),
+		"X-User-Is-Admin": strconv.FormatBool(act.IsAdmin),
+	}
+	optional := map[string]string{
+		"X-User-Public-Id":  act.PublicID,
+		"X-User-Name":       act.Name,
+		"X-User-Email":      act.Email,
+		"X-User-Avatar-Url": act.AvatarUrl,
+		"X-User-Roles":      strings.Join(act.Roles, ","),
+		"X-User-Scopes":     strings.Join(act.Scopes, ","),
+	}
+	if act.TenantID != 0 {
+		optional["X-User-Tenant-Id"] = strconv.FormatUint(act.TenantID, 10)
+	}
+	for name, value := range optional {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+
+	SignIdentityHeaders(headers, secret)
+	return headers
+}
+
+// SignIdentityHeaders adds the timestamp and the signature covering all the
+// X-User-* headers of the map, e.g. to sign custom identity headers.
+func SignIdentityHeaders(headers map[string]string, secret []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	var pairs [][2]string
+	for name, value := range headers {
+		pairs = append(pairs, [2]string{name, value})
+	}
+
+	headers[IdentityTimestampHeader] = timestamp
+	headers[IdentitySignatureHeader] = signIdentity(secret, timestamp, pairs)
+}
+
+// signIdentity computes the signature of the X-User-* headers among pairs, the
+// canonical form is the timestamp followed by the sorted "name:value" lines
+// with lower case names.
+func signIdentity(secret []byte, timestamp string, pairs [][2]string) string {
+	var lines []string
+	for _, pair := range pairs {
+		name := strings.ToLower(pair[0])
+		if strings.HasPrefix(name, identityHeaderPrefix) {
+			lines = append(lines, name+":"+pair[1])
+		}
+	}
+	sort.Strings(lines)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	for _, line := range lines {
+		mac.Write([]byte("\n" + line))
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyIdentity checks the signature and the freshness of identity headers.
+func verifyIdentity(secret []byte, maxAge time.Duration, timestamp string, signature string, pairs [][2]string) error {
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("missing identity signature")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid identity timestamp")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("stale identity signature")
+	}
+
+	if !hmac.Equal([]byte(signIdentity(secret, timestamp, pairs)), []byte(signature)) {
+		return fmt.Errorf("invalid identity signature")
+	}
+	return nil
+}
+
+// proxyAuthSettings is the parsed ProxyAuthConfig.
+type proxyAuthSettings struct {
+	secret          []byte
+	maxAge          time.Duration
+	trustedNetworks []netip.Prefix
+}
+
+func newProxyAuthSettings(config ...ProxyAuthConfig) *proxyAuthSettings {
+	cfg := ProxyAuthConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	settings := &proxyAuthSettings{
+		secret: cfg.Secret,
+		maxAge: cfg.MaxAge,
+	}
+	if len(settings.secret) == 0 {
+		settings.secret = []byte(system.Env("PROXY_AUTH_SECRET"))
+	}
+	if settings.maxAge <= 0 {
+		settings.maxAge = defaultIdentityMaxAge
+	}
+
+	networks := cfg.TrustedNetworks
+	if len(networks) == 0 {
+		networks = strings.Split(system.Env("PROXY_TRUSTED_NETWORKS"), ",")
+	}
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+		prefix, err := parseNetwork(network)
+		if err != nil {
+			system.Logger.Errorf("Ignoring invalid trusted network %q: %v", network, err)
+			continue
+		}
+		settings.trustedNetworks = append(settings.trustedNetworks, prefix)
+	}
+
+	return settings
+}
+
+// isTrusted reports whether unsigned identity headers are accepted from the peer.
+func (s *proxyAuthSettings) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedNetworks {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetwork parses a CIDR or a single IP.
+func parseNetwork(network string) (netip.Prefix, error) {
+	if strings.Contains(network, "/") {
+		return netip.ParsePrefix(network)
+	}
+	addr, err := netip.ParseAddr(network)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -3,7 +3,9 @@ package auth
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,6 +15,12 @@ import (
 )
 
 func GenerateToken(keyManager *commonJWT.KeyManager, data *AuthTokenData, expiration ...time.Duration) (*string, error) {
+	return GenerateTokenWithClaims(keyManager, data, nil, expiration...)
+}
+
+// GenerateTokenWithClaims generates a token carrying the given registered claims,
+// e.g. the issuer and the audience it is minted for. The subject defaults to the account ID.
+func GenerateTokenWithClaims(keyManager *commonJWT.KeyManager, data *AuthTokenData, claims *commonJWT.Claims, expiration ...time.Duration) (*string, error) {
 	var duration time.Duration
 	if len(expiration) > 0 {
 		duration = expiration[0]
@@ -25,14 +33,23 @@ func GenerateToken(keyManager *commonJWT.KeyManager, data *AuthTokenData, expira
 		return nil, err
 	}
 
+	tokenClaims := commonJWT.Claims{}
+	if claims != nil {
+		tokenClaims = *claims
+	}
+	if tokenClaims.Subject == "" && data.ID != 0 {
+		tokenClaims.Subject = strconv.FormatUint(data.ID, 10)
+	}
+
 	jweOptions := &commonJWT.JWEOptions{
 		ExpiresIn: duration,
+		Claims:    &tokenClaims,
 		// Headers: map[string]interface{}{
 		// 	"custom-header": "custom-value",
 		// },
 	}
 
-	token, err := keyManager.IssueJWE(mashalledData, jweOptions)
+	token, err := keyManager.IssueToken(mashalledData, jweOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -42,17 +59,12 @@ func GenerateToken(keyManager *commonJWT.KeyManager, data *AuthTokenData, expira
 }
 
 func ParseToken(keyManager *commonJWT.KeyManager, token string) (*AuthTokenData, error) {
-	if token == "" {
-		return nil, fmt.Errorf("empty token")
-	}
-
-	decrypted, err := keyManager.DecryptJWE([]byte(token))
+	decrypted, claims, err := verifyToken(keyManager, token)
 	if err != nil {
-		keyManager.RefreshKeys()
-		decrypted, err = keyManager.DecryptJWE([]byte(token))
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt token: %w", err)
-		}
+		return nil, err
+	}
+	if claims.Type == RefreshTokenType {
+		return nil, fmt.Errorf("%w: refresh token cannot be used as access token", ErrInvalidToken)
 	}
 
 	dec := json.NewDecoder(bytes.NewReader(decrypted))
@@ -61,17 +73,44 @@ func ParseToken(keyManager *commonJWT.KeyManager, token string) (*AuthTokenData,
 
 	// Decode the JSON into the types.Map
 	if err := dec.Decode(data); err != nil {
-		return nil, fmt.Errorf("failed to decode token payload: %w", err)
+		return nil, fmt.Errorf("%w: failed to decode token payload: %v", ErrMalformed, err)
+	}
+
+	if err := checkRevocation(claims, data.ID); err != nil {
+		return nil, err
 	}
 
 	return data, nil
 }
 
+// verifyToken verifies the token, retrying once when its key was unknown and
+// has been loaded by a rate-limited refresh.
+func verifyToken(keyManager *commonJWT.KeyManager, token string) ([]byte, *commonJWT.Claims, error) {
+	if token == "" {
+		return nil, nil, ErrMissingToken
+	}
+
+	decrypted, claims, err := keyManager.VerifyTokenClaims([]byte(token))
+	if errors.Is(err, commonJWT.ErrUnknownKey) && keyManager.RefreshForToken([]byte(token)) {
+		decrypted, claims, err = keyManager.VerifyTokenClaims([]byte(token))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt token: %w", err)
+	}
+
+	return decrypted, claims, nil
+}
+
+// IsApiKey reports whether the token has the "<prefix>.<secret>" format of API
+// keys, see GormApiKeyStore. Tokens have either two or four dots.
 func IsApiKey(token string) bool {
 	dotIndex := strings.IndexByte(token, '.')
 	if dotIndex == -1 || dotIndex != 8 || dotIndex == len(token)-1 {
 		return false
 	}
+	if strings.IndexByte(token[dotIndex+1:], '.') != -1 {
+		return false
+	}
 
 	return true
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	commonJWT "github.com/tphan267/common/jwt"
@@ -54,7 +55,7 @@ func TestGenerateAndParseToken_Success(t *testing.T) {
 	assert.NotNil(t, parsedData, "Parsed data should not be nil")
 
 	// Verify that the parsed data matches the original data
-	assert.Equal(t, data, *parsedData, "Parsed data should match the original data")
+	assert.Equal(t, *data, *parsedData, "Parsed data should match the original data")
 }
 
 // TestParseToken_Expired tests parsing of an expired token
@@ -147,7 +148,7 @@ func TestGenerateToken_CustomExpiration(t *testing.T) {
 	assert.NotNil(t, parsedData, "Parsed data should not be nil")
 
 	// Verify that the parsed data matches the original data
-	assert.Equal(t, data, *parsedData, "Parsed data should match the original data")
+	assert.Equal(t, *data, *parsedData, "Parsed data should match the original data")
 }
 
 // TestGenerateToken_DefaultExpiration tests generating a token with default expiration duration
@@ -171,7 +172,55 @@ func TestGenerateToken_DefaultExpiration(t *testing.T) {
 	assert.NotNil(t, parsedData, "Parsed data should not be nil")
 
 	// Verify that the parsed data matches the original data
-	assert.Equal(t, data, *parsedData, "Parsed data should match the original data")
+	assert.Equal(t, *data, *parsedData, "Parsed data should match the original data")
+}
+
+// TestGenerateAndParseToken_Signed tests tokens issued by a signing KeyManager
+func TestGenerateAndParseToken_Signed(t *testing.T) {
+	store := commonJWT.NewInMemoryKeyStore()
+	km, err := commonJWT.NewSigningKeyManager(jwa.ES256(), 24*time.Hour, store)
+	require.NoError(t, err, "Failed to initialize signing KeyManager")
+	defer km.Shutdown()
+
+	data := &AuthTokenData{
+		ID:   1,
+		Name: "signed",
+	}
+
+	token, err := GenerateToken(km, data)
+	require.NoError(t, err, "GenerateToken should not return an error")
+	assert.True(t, commonJWT.IsJWS([]byte(*token)), "Generated token should be a JWS")
+
+	parsedData, err := ParseToken(km, *token)
+	require.NoError(t, err, "ParseToken should not return an error")
+	assert.Equal(t, *data, *parsedData, "Parsed data should match the original data")
+}
+
+// TestParseToken_AudienceReplay tests that a token minted for another service is rejected
+func TestParseToken_AudienceReplay(t *testing.T) {
+	store := commonJWT.NewInMemoryKeyStore()
+	km, err := commonJWT.NewIssuerKeyManager(24*time.Hour, store)
+	require.NoError(t, err, "Failed to initialize KeyManager")
+	defer km.Shutdown()
+
+	serviceA, err := commonJWT.NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to initialize validation KeyManager")
+	serviceA.SetValidationPolicy(commonJWT.ValidationPolicy{Audiences: []string{"service-a"}})
+
+	serviceB, err := commonJWT.NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to initialize validation KeyManager")
+	serviceB.SetValidationPolicy(commonJWT.ValidationPolicy{Audiences: []string{"service-b"}})
+
+	data := &AuthTokenData{ID: 7}
+	token, err := GenerateTokenWithClaims(km, data, &commonJWT.Claims{Audience: []string{"service-a"}})
+	require.NoError(t, err, "GenerateTokenWithClaims should not return an error")
+
+	parsedData, err := ParseToken(serviceA, *token)
+	require.NoError(t, err, "ParseToken should accept the token for its audience")
+	assert.Equal(t, *data, *parsedData, "Parsed data should match the original data")
+
+	_, err = ParseToken(serviceB, *token)
+	assert.Error(t, err, "ParseToken should reject a token minted for another audience")
 }
 
 // TestIsApiKey tests if tokens are valid
@@ -190,6 +239,7 @@ func TestIsApiKey(t *testing.T) {
 		{"Invalid - Only Dot", ".", false},
 		{"Invalid - Dot at Start", ".abcdefgh", false},
 		{"Invalid - Dot at End", "abcdefgh.", false},
+		{"Invalid - Token Segments", "abcdefgh.12345.6789", false},
 	}
 
 	for _, tt := range tests {
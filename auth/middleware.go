@@ -1,13 +1,16 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tphan267/common/api"
 	"github.com/tphan267/common/cache"
 	"github.com/tphan267/common/http"
+	commonJWT "github.com/tphan267/common/jwt"
 	"github.com/tphan267/common/system"
 	"github.com/tphan267/common/utils"
 )
@@ -32,43 +35,230 @@ func remoteMiddleware(extractTokens ...string) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		token := ExtractToken(ctx, extractTokens...)
 		if token == "" {
-			return api.ErrorUnauthorizedResp(ctx, "Missing auth token or apikey")
+			return ErrorAuthResp(ctx, ErrMissingToken)
 		}
 
-		act, err := RemoteAccount(token)
+		act, err := RemoteAccountContext(ctx.UserContext(), token)
 		if err != nil {
-			return api.ErrorUnauthorizedResp(ctx, err.Error())
+			return ErrorAuthResp(ctx, err)
 		}
 
-		ctx.Locals("authToken", token)
-		ctx.Locals("account", act)
-		ctx.Locals("uiID", act.ID)
-		ctx.Locals("usID", fmt.Sprintf("%d", act.ID))
+		setAccountLocals(ctx, token, act)
 
 		return ctx.Next()
 	}
 }
 
-func RemoteAccount(token string) (act *AuthTokenData, err error) {
+// LocalAuthMiddleware decrypts tokens locally with the KeyManager, e.g. a
+// validation KeyManager sharing keys through RemoteStore, instead of calling
+// AUTH_API. API keys are still validated by RemoteAccount. Tokens are looked up
+// like RemoteMiddleware unless extractTokens are given.
+func LocalAuthMiddleware(keyManager *commonJWT.KeyManager, extractTokens ...string) fiber.Handler {
+	if len(extractTokens) == 0 {
+		extractTokens = []string{"header:Authorization,query:auth_token,query:apikey"}
+	}
+
+	return func(ctx *fiber.Ctx) error {
+		token := ExtractToken(ctx, extractTokens...)
+		if token == "" {
+			return ErrorAuthResp(ctx, ErrMissingToken)
+		}
+
+		var act *AuthTokenData
+		var err error
+		if IsApiKey(token) {
+			act, err = RemoteAccountContext(ctx.UserContext(), token)
+		} else {
+			act, err = ParseToken(keyManager, token)
+		}
+		if err != nil {
+			return ErrorAuthResp(ctx, err)
+		}
+
+		setAccountLocals(ctx, token, act)
+
+		return ctx.Next()
+	}
+}
+
+// setAccountLocals stores the authenticated account in the ctx.Locals read by
+// handlers and by database.TenantScope.
+func setAccountLocals(ctx *fiber.Ctx, token string, act *AuthTokenData) {
+	ctx.Locals("authToken", token)
+	ctx.Locals("account", act)
+	ctx.Locals("uiID", act.ID)
+	ctx.Locals("usID", fmt.Sprintf("%d", act.ID))
+	ctx.Locals("tenantID", act.TenantID)
+}
+
+// AuthClient sends the requests to AUTH_API. Requests are sent within a short
+// timeout and retried once, the concurrency is limited and a circuit breaker
+// opens during outages, so a slow auth service fails requests fast instead of
+// holding every worker. Use InitAuthClient to configure it.
+var AuthClient = http.NewClient(authClientOptions())
+
+// InitAuthClient sets the options of AuthClient. Without arguments they are
+// read from the environment:
+//   - AUTH_API_TIMEOUT bounds each attempt, defaults to 3s.
+//   - AUTH_API_MAX_RETRIES is the number of retries of idempotent requests, defaults to 1.
+//   - AUTH_API_MAX_CONCURRENT limits the requests in flight, defaults to 64.
+//   - AUTH_API_QUEUE_WAIT is how long requests wait for a free slot, defaults to 1s.
+//   - AUTH_API_BREAKER_THRESHOLD is the number of consecutive failures opening
+//     the circuit breaker, defaults to 5, 0 disables it.
+func InitAuthClient(opts ...http.ClientOptions) {
+	if len(opts) > 0 {
+		AuthClient = http.NewClient(opts[0])
+		return
+	}
+	AuthClient = http.NewClient(authClientOptions())
+}
+
+func authClientOptions() http.ClientOptions {
+	timeout, err := utils.ParseDuration(system.Env("AUTH_API_TIMEOUT", "3s"))
+	if err != nil {
+		timeout = 3 * time.Second
+	}
+	queueWait, err := utils.ParseDuration(system.Env("AUTH_API_QUEUE_WAIT", "1s"))
+	if err != nil {
+		queueWait = time.Second
+	}
+	retries := system.EnvInt("AUTH_API_MAX_RETRIES", 1)
+	if retries == 0 {
+		retries = -1
+	}
+
+	return http.ClientOptions{
+		Timeout:              timeout,
+		MaxRetries:           retries,
+		MaxConcurrentPerHost: system.EnvInt("AUTH_API_MAX_CONCURRENT", 64),
+		MaxQueueWait:         queueWait,
+		Breaker: http.BreakerOptions{
+			FailureThreshold: system.EnvInt("AUTH_API_BREAKER_THRESHOLD", 5),
+		},
+	}
+}
+
+// RemoteAccount returns the account of the token, see RemoteAccountContext.
+func RemoteAccount(token string) (*AuthTokenData, error) {
+	return RemoteAccountContext(context.Background(), token)
+}
+
+// RemoteAccountContext returns the account of the token, validated by AUTH_API
+// within ctx, e.g. the fiber UserContext of the request, and cached for
+// AUTH_CACHE_DURATION. When AUTH_STALE_DURATION is set, accounts are kept that
+// long to be served while the auth service is unavailable, e.g. its circuit
+// breaker is open. Rejected tokens are never served stale.
+func RemoteAccountContext(ctx context.Context, token string) (act *AuthTokenData, err error) {
 	act = &AuthTokenData{}
-	err = cache.GetObj(token, act)
+	err = accountCache.GetObj(token, act)
 
 	if err != nil || act.ID == 0 {
 		err = nil
-		resp := &AuthValidateResponse{}
-		err := http.Get(system.Env("AUTH_API")+"/auth/validate", resp, map[string]string{
+		act, _, err = http.Do[*AuthTokenData](ctx, AuthClient, "GET", system.Env("AUTH_API")+"/auth/validate", nil, map[string]string{
 			"Authorization": "Bearer " + token,
 		})
 		if err != nil {
+			err = remoteRequestError(err)
+			if !errors.Is(err, ErrAuthUnavailable) {
+				forgetAccount(token)
+				return nil, err
+			}
+			if stale := staleAccount(token, err); stale != nil {
+				return stale, nil
+			}
 			return nil, err
 		}
-		if !resp.Success {
-			return nil, errors.New(resp.Error.Message)
+		if act == nil {
+			forgetAccount(token)
+			return nil, ErrInvalidToken
 		}
-		act = resp.Data
 		duration, _ := utils.ParseDuration(system.Env("AUTH_CACHE_DURATION", "1h"))
-		cache.SetObj(token, act, duration)
+		accountCache.SetObj(token, act, duration)
+		if staleDuration := authStaleDuration(); staleDuration > 0 {
+			accountCache.SetObj(staleAccountKey(token), act, staleDuration)
+		}
 	}
 
 	return act, err
 }
+
+// staleAccount returns the last account validated for the token when err is
+// an outage of the auth service, nil otherwise.
+func staleAccount(token string, err error) *AuthTokenData {
+	if !errors.Is(err, ErrAuthUnavailable) || authStaleDuration() <= 0 {
+		return nil
+	}
+
+	act := &AuthTokenData{}
+	if accountCache.GetObj(staleAccountKey(token), act) != nil || act.ID == 0 {
+		return nil
+	}
+	return act
+}
+
+// forgetAccount removes the cached accounts of a token rejected by the auth
+// service, so that it is not served stale during a later outage.
+func forgetAccount(token string) {
+	accountCache.Del(token)
+	accountCache.Del(staleAccountKey(token))
+}
+
+// accountCacheStore is the part of the cache package used to cache the
+// accounts validated by AUTH_API.
+type accountCacheStore interface {
+	GetObj(key string, out any) error
+	SetObj(key string, value any, expiration ...time.Duration) error
+	Del(key string) error
+}
+
+// accountCache is the cache of validated accounts, the cache initialized by
+// cache.InitRedisCache unless replaced by tests.
+var accountCache accountCacheStore = defaultCache{}
+
+type defaultCache struct{}
+
+func (defaultCache) GetObj(key string, out any) error {
+	return cache.GetObj(key, out)
+}
+
+func (defaultCache) SetObj(key string, value any, expiration ...time.Duration) error {
+	return cache.SetObj(key, value, expiration...)
+}
+
+func (defaultCache) Del(key string) error {
+	return cache.Del(key)
+}
+
+func authStaleDuration() time.Duration {
+	duration, _ := utils.ParseDuration(system.Env("AUTH_STALE_DURATION", "0"))
+	return duration
+}
+
+func staleAccountKey(token string) string {
+	return "stale:" + token
+}
+
+// remoteRequestError returns the auth error of rejected requests to the auth
+// API, including unsuccessful responses with a 2xx status, other failures wrap
+// ErrAuthUnavailable.
+func remoteRequestError(err error) error {
+	var httpErr *http.HTTPError
+	if errors.As(err, &httpErr) && (httpErr.StatusCode == fiber.StatusUnauthorized || httpErr.StatusCode == fiber.StatusForbidden ||
+		(httpErr.StatusCode < 300 && httpErr.ApiError != nil)) {
+		return remoteAuthError(httpErr.ApiError)
+	}
+	return authUnavailable(err)
+}
+
+// remoteAuthError returns the error matching the error code sent by the auth API.
+func remoteAuthError(apiErr *api.ApiError) error {
+	if apiErr == nil {
+		return ErrInvalidToken
+	}
+	code, _ := apiErr.Detail.(string)
+	err := AuthErrorFromCode(code)
+	if err != ErrInvalidToken || apiErr.Message == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, apiErr.Message)
+}
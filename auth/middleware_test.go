@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tphan267/common/database"
+	commonJWT "github.com/tphan267/common/jwt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestLocalAuthMiddleware(t *testing.T) {
+	InitRevocationStore(NewInMemoryRevocationStore())
+	store := commonJWT.NewInMemoryKeyStore()
+	issuer, err := commonJWT.NewIssuerKeyManager(24*time.Hour, store)
+	require.NoError(t, err)
+	defer issuer.Shutdown()
+
+	validator, err := commonJWT.NewValidationKeyManager(store)
+	require.NoError(t, err)
+	defer validator.Shutdown()
+
+	app := fiber.New()
+	app.Get("/me", LocalAuthMiddleware(validator), func(c *fiber.Ctx) error {
+		act := c.Locals("account").(*AuthTokenData)
+		assert.Equal(t, uint64(7), c.Locals("uiID"))
+		assert.Equal(t, "7", c.Locals("usID"))
+		assert.NotEmpty(t, c.Locals("authToken"))
+		return c.SendString(act.Name)
+	})
+
+	token, err := GenerateToken(issuer, &AuthTokenData{ID: 7, Name: "John"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+*token)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	// Query tokens are accepted like RemoteMiddleware
+	resp, err = app.Test(httptest.NewRequest("GET", "/me?auth_token="+*token, nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/me", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "Bearer", resp.Header.Get(fiber.HeaderWWWAuthenticate))
+
+	req = httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer not.a.token")
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestTenantScope(t *testing.T) {
+	InitRevocationStore(NewInMemoryRevocationStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	type order struct {
+		ID       uint64
+		TenantID uint64
+		Name     string
+	}
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&order{}))
+	require.NoError(t, db.Create(&[]order{
+		{TenantID: 1, Name: "a"}, {TenantID: 1, Name: "b"}, {TenantID: 2, Name: "c"},
+	}).Error)
+
+	app := fiber.New()
+	app.Get("/orders", LocalAuthMiddleware(km), func(c *fiber.Ctx) error {
+		var orders []order
+		if err := db.Scopes(database.TenantScope(c), database.Paginate(c)).Find(&orders).Error; err != nil {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+		return c.SendString(strconv.Itoa(len(orders)))
+	})
+
+	count := func(data *AuthTokenData) string {
+		token, err := GenerateToken(km, data)
+		require.NoError(t, err)
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+*token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		if resp.StatusCode != fiber.StatusOK {
+			return strconv.Itoa(resp.StatusCode)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	assert.Equal(t, "2", count(&AuthTokenData{ID: 1, TenantID: 1}))
+	assert.Equal(t, "1", count(&AuthTokenData{ID: 2, TenantID: 2}))
+	// Accounts without tenant can't query tenant data
+	assert.Equal(t, "403", count(&AuthTokenData{ID: 3}))
+}
+
+// memoryAccountCache is an accountCacheStore keeping values in memory.
+type memoryAccountCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func useMemoryAccountCache(t *testing.T) *memoryAccountCache {
+	previous := accountCache
+	c := &memoryAccountCache{values: map[string][]byte{}}
+	accountCache = c
+	t.Cleanup(func() { accountCache = previous })
+	return c
+}
+
+func (c *memoryAccountCache) GetObj(key string, out any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[key]
+	if !ok {
+		return errors.New("cache miss")
+	}
+	return json.Unmarshal(value, out)
+}
+
+func (c *memoryAccountCache) SetObj(key string, value any, expiration ...time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = data
+	return nil
+}
+
+func (c *memoryAccountCache) Del(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+	return nil
+}
+
+func TestRemoteAccountContext_StaleAccounts(t *testing.T) {
+	accounts := useMemoryAccountCache(t)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		switch status {
+		case http.StatusOK:
+			w.Write([]byte(`{"success":true,"data":{"id":8,"name":"Jane"}}`))
+		case http.StatusUnauthorized:
+			w.Write([]byte(`{"success":false,"error":{"code":401,"message":"The token was revoked","detail":"token_revoked"}}`))
+		}
+	}))
+	defer server.Close()
+	t.Setenv("AUTH_API", server.URL)
+	t.Setenv("AUTH_STALE_DURATION", "1h")
+
+	act, err := RemoteAccountContext(context.Background(), "token")
+	require.NoError(t, err)
+	assert.EqualValues(t, 8, act.ID)
+
+	// Accounts are served stale during an outage once the cache expired
+	accounts.Del("token")
+	status = http.StatusServiceUnavailable
+	act, err = RemoteAccountContext(context.Background(), "token")
+	require.NoError(t, err)
+	assert.EqualValues(t, 8, act.ID)
+
+	// A rejection forgets the account
+	status = http.StatusUnauthorized
+	_, err = RemoteAccountContext(context.Background(), "token")
+	assert.ErrorIs(t, err, ErrRevoked)
+	assert.Empty(t, accounts.values, "Rejected tokens should not stay cached")
+
+	status = http.StatusServiceUnavailable
+	_, err = RemoteAccountContext(context.Background(), "token")
+	assert.ErrorIs(t, err, ErrAuthUnavailable, "Rejected tokens should not be served stale")
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tphan267/common/api"
+)
+
+// ErrorCodeInsufficientScope is the error code of requests denied by a policy middleware.
+const ErrorCodeInsufficientScope = "insufficient_scope"
+
+// HasRole reports whether the account has the role.
+func (d *AuthTokenData) HasRole(role string) bool {
+	return slices.Contains(d.Roles, role)
+}
+
+// HasScope reports whether the scope is granted to the account.
+func (d *AuthTokenData) HasScope(scope string) bool {
+	return slices.Contains(d.Scopes, scope)
+}
+
+// Account returns the account stored by the auth middlewares, nil when the
+// request is not authenticated.
+func Account(c *fiber.Ctx) *AuthTokenData {
+	act, _ := c.Locals("account").(*AuthTokenData)
+	return act
+}
+
+// TenantID returns the tenant of the authenticated account, zero when missing.
+func TenantID(c *fiber.Ctx) uint64 {
+	tenantID, _ := c.Locals("tenantID").(uint64)
+	return tenantID
+}
+
+// RequireScopes allows requests of accounts granted all the scopes. It must be
+// used after one of the auth middlewares.
+func RequireScopes(scopes ...string) fiber.Handler {
+	return policyMiddleware(scopes, func(_ *fiber.Ctx, act *AuthTokenData) bool {
+		for _, scope := range scopes {
+			if !act.HasScope(scope) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// RequireAnyScope allows requests of accounts granted at least one of the scopes.
+func RequireAnyScope(scopes ...string) fiber.Handler {
+	return policyMiddleware(scopes, func(_ *fiber.Ctx, act *AuthTokenData) bool {
+		return slices.ContainsFunc(scopes, act.HasScope)
+	})
+}
+
+// RequireAnyRole allows requests of accounts having at least one of the roles.
+func RequireAnyRole(roles ...string) fiber.Handler {
+	return policyMiddleware(nil, func(_ *fiber.Ctx, act *AuthTokenData) bool {
+		return slices.ContainsFunc(roles, act.HasRole)
+	})
+}
+
+// Require allows requests for which the predicate returns true, e.g. to check
+// that the account owns the requested resource.
+func Require(predicate func(c *fiber.Ctx, act *AuthTokenData) bool) fiber.Handler {
+	return policyMiddleware(nil, predicate)
+}
+
+// policyMiddleware responds 401 to unauthenticated requests and 403 when the
+// predicate denies the request, the scopes are advertised in the challenge.
+func policyMiddleware(scopes []string, predicate func(c *fiber.Ctx, act *AuthTokenData) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		act := Account(c)
+		if act == nil {
+			return ErrorAuthResp(c, ErrMissingToken)
+		}
+		if predicate(c, act) {
+			return c.Next()
+		}
+
+		challenge := `Bearer error="insufficient_scope"`
+		if len(scopes) > 0 {
+			challenge += fmt.Sprintf(`, scope=%q`, strings.Join(scopes, " "))
+		}
+		c.Set(fiber.HeaderWWWAuthenticate, challenge)
+
+		return api.ErrorResp(c, api.ApiError{
+			Code:    fiber.StatusForbidden,
+			Message: "Forbidden",
+			Detail:  ErrorCodeInsufficientScope,
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyMiddlewares(t *testing.T) {
+	InitRevocationStore(NewInMemoryRevocationStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	app := fiber.New()
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	app.Get("/orders", LocalAuthMiddleware(km), RequireScopes("orders:read", "orders:write"), ok)
+	app.Get("/reports", LocalAuthMiddleware(km), RequireAnyScope("reports:read", "reports:admin"), ok)
+	app.Get("/tenant", LocalAuthMiddleware(km), RequireAnyRole("tenant-admin", "owner"), ok)
+	app.Get("/users/:id", LocalAuthMiddleware(km), Require(func(c *fiber.Ctx, act *AuthTokenData) bool {
+		return c.Params("id") == c.Locals("usID")
+	}), ok)
+	secret := []byte("proxy-secret")
+	app.Get("/proxy", ProxyAuthMiddleware(ProxyAuthConfig{Secret: secret}), RequireAnyRole("tenant-admin"), RequireScopes("orders:write"), ok)
+	app.Get("/anonymous", RequireScopes("orders:read"), ok)
+
+	token, err := GenerateToken(km, &AuthTokenData{
+		ID:     3,
+		Roles:  []string{"tenant-admin"},
+		Scopes: []string{"orders:read", "reports:read"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"/orders", map[string]string{"Authorization": "Bearer " + *token}, fiber.StatusForbidden},
+		{"/reports", map[string]string{"Authorization": "Bearer " + *token}, fiber.StatusOK},
+		{"/tenant", map[string]string{"Authorization": "Bearer " + *token}, fiber.StatusOK},
+		{"/users/3", map[string]string{"Authorization": "Bearer " + *token}, fiber.StatusOK},
+		{"/users/4", map[string]string{"Authorization": "Bearer " + *token}, fiber.StatusForbidden},
+		{"/proxy", map[string]string{"X-User-Id": "3", "X-User-Roles": "tenant-admin", "X-User-Scopes": "orders:read,orders:write"}, fiber.StatusOK},
+		{"/proxy", map[string]string{"X-User-Id": "3", "X-User-Roles": "member", "X-User-Scopes": "orders:write"}, fiber.StatusForbidden},
+		{"/anonymous", nil, fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		if tt.path == "/proxy" {
+			SignIdentityHeaders(tt.headers, secret)
+		}
+		req := httptest.NewRequest("GET", tt.path, nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, tt.status, resp.StatusCode, "GET %s with %v", tt.path, tt.headers)
+		if tt.status == fiber.StatusForbidden && tt.path == "/orders" {
+			assert.Equal(t, `Bearer error="insufficient_scope", scope="orders:read orders:write"`, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+		}
+	}
+}
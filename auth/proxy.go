@@ -10,7 +10,12 @@ import (
 	"github.com/tphan267/common/strcase"
 )
 
-func ProxyAuthMiddleware() fiber.Handler {
+// ProxyAuthMiddleware builds the account from the X-User-* headers set by a
+// gateway. The headers must be signed with SignIdentityHeaders, unsigned headers
+// are only accepted from the configured trusted networks.
+func ProxyAuthMiddleware(config ...ProxyAuthConfig) fiber.Handler {
+	settings := newProxyAuthSettings(config...)
+
 	return func(c *fiber.Ctx) error {
 		// Check if the required header "x-user-id" exists.
 		userID := c.Get("x-user-id")
@@ -18,29 +23,47 @@ func ProxyAuthMiddleware() fiber.Handler {
 			return api.ErrorUnauthorizedResp(c, "Unauthorized: Missing x-user-id header")
 		}
 
+		var headers [][2]string
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			headers = append(headers, [2]string{string(key), string(value)})
+		})
+
+		// Verify the signature, unless an unsigned request comes from a trusted network
+		signature := c.Get(IdentitySignatureHeader)
+		if signature != "" || !settings.isTrusted(c.Context().RemoteIP().String()) {
+			if len(settings.secret) == 0 {
+				return api.ErrorUnauthorizedResp(c, "Unauthorized: identity signature is not configured")
+			}
+			if err := verifyIdentity(settings.secret, settings.maxAge, c.Get(IdentityTimestampHeader), signature, headers); err != nil {
+				return api.ErrorUnauthorizedResp(c, "Unauthorized: "+err.Error())
+			}
+		}
+
 		// Collect all headers that have the prefix "x-user-".
 		userData := make(map[string]interface{})
-		c.Request().Header.VisitAll(func(key, value []byte) {
-			headerKey := string(key)
+		for _, header := range headers {
+			headerKey, value := header[0], header[1]
 			if strings.HasPrefix(headerKey, "X-User-") {
 				key := strcase.LowerCamelCase(strings.TrimPrefix(headerKey, "X-User-"))
-				if key == "id" {
-					id, _ := strconv.Atoi(string(value))
+				if key == "id" || key == "tenantId" {
+					id, _ := strconv.Atoi(value)
 					userData[key] = uint64(id)
 				} else if key == "isAdmin" {
-					userData[key] = string(value) == "true"
+					userData[key] = value == "true"
+				} else if key == "roles" || key == "scopes" {
+					userData[key] = splitHeaderList(value)
 				} else {
-					userData[key] = string(value)
+					userData[key] = value
 				}
 			}
-		})
+		}
 
 		// convert to AuthTokenData
 		authData := &AuthTokenData{}
 		jsonStr, _ := json.Marshal(userData)
 		_ = json.Unmarshal(jsonStr, authData)
 
-		c.Locals("account", authData)
+		setAccountLocals(c, "", authData)
 
 		// Proceed to the next middleware or final handler.
 		return c.Next()
@@ -57,8 +80,13 @@ func IsAdminMiddleware() fiber.Handler {
 }
 
 func IsAdmin(c *fiber.Ctx) bool {
-	if authData := c.Locals("account"); authData != nil {
-		return authData.(*AuthTokenData).IsAdmin
-	}
-	return false
+	act := Account(c)
+	return act != nil && act.IsAdmin
+}
+
+// splitHeaderList splits a comma or space separated header value.
+func splitHeaderList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
 }
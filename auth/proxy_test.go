@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyAuthMiddleware_SignedHeaders(t *testing.T) {
+	secret := []byte("proxy-secret")
+
+	app := fiber.New()
+	app.Get("/me", ProxyAuthMiddleware(ProxyAuthConfig{Secret: secret}), func(c *fiber.Ctx) error {
+		act := Account(c)
+		assert.Equal(t, uint64(12), act.ID)
+		assert.Equal(t, "abcdefgh", act.PublicID)
+		assert.Equal(t, "Jane Doe", act.Name)
+		assert.True(t, act.IsAdmin)
+		assert.Equal(t, []string{"tenant-admin", "billing"}, act.Roles)
+		assert.Equal(t, uint64(4), TenantID(c))
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	send := func(headers map[string]string) int {
+		req := httptest.NewRequest("GET", "/me", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	act := &AuthTokenData{ID: 12, PublicID: "abcdefgh", Name: "Jane Doe", IsAdmin: true, TenantID: 4, Roles: []string{"tenant-admin", "billing"}}
+	assert.Equal(t, fiber.StatusOK, send(IdentityHeaders(act, secret)))
+
+	// Unsigned headers are rejected outside trusted networks
+	assert.Equal(t, fiber.StatusUnauthorized, send(map[string]string{"X-User-Id": "12", "X-User-Is-Admin": "true"}))
+
+	// Escalating privileges breaks the signature
+	headers := IdentityHeaders(&AuthTokenData{ID: 12, Name: "Jane Doe"}, secret)
+	headers["X-User-Is-Admin"] = "true"
+	assert.Equal(t, fiber.StatusUnauthorized, send(headers))
+
+	// Adding a header breaks the signature too
+	headers = IdentityHeaders(act, secret)
+	headers["X-User-Scopes"] = "orders:write"
+	assert.Equal(t, fiber.StatusUnauthorized, send(headers))
+
+	// Signatures of another secret are rejected
+	assert.Equal(t, fiber.StatusUnauthorized, send(IdentityHeaders(act, []byte("other-secret"))))
+
+	// Stale signatures are rejected
+	headers = map[string]string{"X-User-Id": "12"}
+	SignIdentityHeaders(headers, secret)
+	stale := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	headers[IdentityTimestampHeader] = stale
+	headers[IdentitySignatureHeader] = signIdentity(secret, stale, [][2]string{{"X-User-Id", "12"}})
+	assert.Equal(t, fiber.StatusUnauthorized, send(headers))
+}
+
+func TestProxyAuthMiddleware_TrustedNetworks(t *testing.T) {
+	app := fiber.New()
+	app.Get("/trusted", ProxyAuthMiddleware(ProxyAuthConfig{TrustedNetworks: []string{"0.0.0.0", "10.0.0.0/8"}}), func(c *fiber.Ctx) error {
+		return c.SendString(strconv.FormatUint(Account(c).ID, 10))
+	})
+	app.Get("/untrusted", ProxyAuthMiddleware(ProxyAuthConfig{TrustedNetworks: []string{"10.0.0.0/8"}}), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	// Requests of app.Test come from 0.0.0.0
+	req := httptest.NewRequest("GET", "/trusted", nil)
+	req.Header.Set("X-User-Id", "5")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	req = httptest.NewRequest("GET", "/untrusted", nil)
+	req.Header.Set("X-User-Id", "5")
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/tphan267/common/api"
+	"github.com/tphan267/common/database"
+	commonJWT "github.com/tphan267/common/jwt"
+	"github.com/tphan267/common/system"
+	"github.com/tphan267/common/utils"
+)
+
+// RefreshTokenType is the "typ" header of refresh tokens, they are rejected by ParseToken.
+const RefreshTokenType = "refresh"
+
+var (
+	// ErrRefreshTokenReused is returned when a rotated refresh token is used again.
+	// The whole token family is revoked when it happens.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrRefreshTokenFamilyRevoked is returned for refresh tokens of a revoked family.
+	ErrRefreshTokenFamilyRevoked = errors.New("refresh token family has been revoked")
+)
+
+// TokenPair is returned when exchanging a refresh token.
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refreshTokenPayload only identifies the user, the account is reloaded with an
+// AccountLoader at rotation so access tokens reflect its current state.
+type refreshTokenPayload struct {
+	FamilyID string `json:"fid"`
+	UserID   uint64 `json:"uid"`
+}
+
+// AccountLoader loads the current account data of a user, e.g. from the
+// database. It returns nil without error when the account no longer exists.
+type AccountLoader func(userID uint64) (*AuthTokenData, error)
+
+// RefreshTokenStore tracks refresh token families. A family starts with the
+// first refresh token issued at login and only its latest token is valid.
+type RefreshTokenStore interface {
+	// CreateFamily starts a family, its expiry is the absolute deadline of the
+	// family, rotations never extend it.
+	CreateFamily(familyID string, userID uint64, tokenID string, expiry time.Time) error
+	// RotateFamily replaces the current token of the family, expiring it at
+	// expiry or the deadline of the family, whichever comes first. It returns
+	// ErrRefreshTokenReused when tokenID is not the current token of the family,
+	// ErrRefreshTokenFamilyRevoked or ErrInvalidToken for revoked or unknown
+	// families. Other errors are reported as ErrAuthUnavailable.
+	RotateFamily(familyID string, tokenID string, nextTokenID string, expiry time.Time) error
+	RevokeFamily(familyID string) error
+}
+
+var refreshTokenStore RefreshTokenStore = NewInMemoryRefreshTokenStore()
+
+// InitRefreshTokenStore sets the store used for refresh token rotation. Without
+// arguments it uses database.RedisClient when initialized, an in-memory store otherwise.
+func InitRefreshTokenStore(store ...RefreshTokenStore) {
+	switch {
+	case len(store) > 0:
+		refreshTokenStore = store[0]
+	case database.RedisClient != nil:
+		refreshTokenStore = NewRedisRefreshTokenStore(database.RedisClient)
+	default:
+		refreshTokenStore = NewInMemoryRefreshTokenStore()
+	}
+}
+
+// IssueRefreshToken starts a new refresh token family for the account, e.g. at login.
+func IssueRefreshToken(keyManager *commonJWT.KeyManager, data *AuthTokenData) (string, error) {
+	familyID, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	token, tokenID, expiry, err := generateRefreshToken(keyManager, familyID, data)
+	if err != nil {
+		return "", err
+	}
+
+	if err := refreshTokenStore.CreateFamily(familyID, data.ID, tokenID, expiry); err != nil {
+		return "", authUnavailable(err)
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new access token and a new
+// refresh token of the same family. The access token is issued for the account
+// returned by loadAccount, so role or tenant changes and deleted accounts are
+// taken into account. Reusing an already rotated refresh token revokes the
+// whole family.
+func RotateRefreshToken(keyManager *commonJWT.KeyManager, refreshToken string, loadAccount AccountLoader) (*TokenPair, error) {
+	payload, claims, err := parseRefreshToken(keyManager, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := loadAccount(payload.UserID)
+	if err != nil {
+		return nil, authUnavailable(fmt.Errorf("failed to load account: %w", err))
+	}
+	if account == nil || account.ID != payload.UserID {
+		return nil, fmt.Errorf("%w: account %d no longer exists", ErrInvalidToken, payload.UserID)
+	}
+
+	// Both tokens are signed before consuming the refresh token, a signing
+	// failure leaves it valid
+	token, tokenID, expiry, err := generateRefreshToken(keyManager, payload.FamilyID, account)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := GenerateToken(keyManager, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := refreshTokenStore.RotateFamily(payload.FamilyID, claims.ID, tokenID, expiry); err != nil {
+		switch {
+		case errors.Is(err, ErrRefreshTokenReused):
+			system.Logger.Warnf("Refresh token reuse detected, revoking family of user %d", payload.UserID)
+			if err := refreshTokenStore.RevokeFamily(payload.FamilyID); err != nil {
+				return nil, authUnavailable(err)
+			}
+			return nil, err
+		case errors.Is(err, ErrRefreshTokenFamilyRevoked), errors.Is(err, ErrInvalidToken):
+			return nil, err
+		}
+		return nil, authUnavailable(err)
+	}
+
+	return &TokenPair{
+		AccessToken:  *accessToken,
+		RefreshToken: token,
+	}, nil
+}
+
+// RevokeRefreshToken revokes the family of the refresh token, e.g. at logout.
+func RevokeRefreshToken(keyManager *commonJWT.KeyManager, refreshToken string) error {
+	payload, _, err := parseRefreshToken(keyManager, refreshToken)
+	if err != nil {
+		return err
+	}
+	return refreshTokenStore.RevokeFamily(payload.FamilyID)
+}
+
+// RefreshTokenAPIHandler returns a handler exchanging the refresh token given as
+// "refreshToken" in the JSON body, or as Bearer token, for a new TokenPair.
+// Use with jwt.WithKeyManager(keyManager, auth.RefreshTokenAPIHandler(loadAccount)).
+func RefreshTokenAPIHandler(loadAccount AccountLoader) func(*fiber.Ctx, *commonJWT.KeyManager) error {
+	return func(c *fiber.Ctx, keyManager *commonJWT.KeyManager) error {
+		return refreshTokenAPIHandler(c, keyManager, loadAccount)
+	}
+}
+
+func refreshTokenAPIHandler(c *fiber.Ctx, keyManager *commonJWT.KeyManager, loadAccount AccountLoader) error {
+	body := struct {
+		RefreshToken string `json:"refreshToken"`
+	}{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return api.ErrorBadRequestResp(c, "Invalid request body")
+		}
+	}
+
+	refreshToken := body.RefreshToken
+	if refreshToken == "" {
+		refreshToken = ExtractToken(c)
+	}
+	if refreshToken == "" {
+		return ErrorAuthResp(c, ErrMissingToken)
+	}
+
+	pair, err := RotateRefreshToken(keyManager, refreshToken, loadAccount)
+	if err != nil {
+		return ErrorAuthResp(c, err)
+	}
+
+	return api.SuccessResp(c, pair)
+}
+
+func generateRefreshToken(keyManager *commonJWT.KeyManager, familyID string, data *AuthTokenData) (string, string, time.Time, error) {
+	duration, _ := utils.ParseDuration(system.Env("JWT_REFRESH_DURATION", "30D"))
+
+	tokenID, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	marshalledData, err := json.Marshal(&refreshTokenPayload{
+		FamilyID: familyID,
+		UserID:   data.ID,
+	})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	token, err := keyManager.IssueToken(marshalledData, &commonJWT.JWEOptions{
+		ExpiresIn: duration,
+		Claims: &commonJWT.Claims{
+			Subject: strconv.FormatUint(data.ID, 10),
+			ID:      tokenID,
+			Type:    RefreshTokenType,
+		},
+	})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	return string(token), tokenID, time.Now().Add(duration), nil
+}
+
+func parseRefreshToken(keyManager *commonJWT.KeyManager, refreshToken string) (*refreshTokenPayload, *commonJWT.Claims, error) {
+	decrypted, claims, err := verifyToken(keyManager, refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.Type != RefreshTokenType {
+		return nil, nil, fmt.Errorf("%w: not a refresh token", ErrInvalidToken)
+	}
+
+	payload := &refreshTokenPayload{}
+	if err := json.Unmarshal(decrypted, payload); err != nil || payload.UserID == 0 {
+		return nil, nil, fmt.Errorf("%w: failed to decode refresh token payload", ErrMalformed)
+	}
+
+	if err := checkRevocation(claims, payload.UserID); err != nil {
+		return nil, nil, err
+	}
+
+	return payload, claims, nil
+}
+
+type refreshTokenFamily struct {
+	userID   uint64
+	current  string
+	revoked  bool
+	expiry   time.Time
+	deadline time.Time
+}
+
+// InMemoryRefreshTokenStore is an in-memory implementation of RefreshTokenStore.
+type InMemoryRefreshTokenStore struct {
+	mu       sync.Mutex
+	families map[string]*refreshTokenFamily
+}
+
+// NewInMemoryRefreshTokenStore initializes a new in-memory refresh token store.
+func NewInMemoryRefreshTokenStore() *InMemoryRefreshTokenStore {
+	return &InMemoryRefreshTokenStore{
+		families: map[string]*refreshTokenFamily{},
+	}
+}
+
+func (s *InMemoryRefreshTokenStore) CreateFamily(familyID string, userID uint64, tokenID string, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired families
+	now := time.Now()
+	for id, family := range s.families {
+		if now.After(family.expiry) {
+			delete(s.families, id)
+		}
+	}
+
+	s.families[familyID] = &refreshTokenFamily{
+		userID:   userID,
+		current:  tokenID,
+		expiry:   expiry,
+		deadline: expiry,
+	}
+	return nil
+}
+
+func (s *InMemoryRefreshTokenStore) RotateFamily(familyID string, tokenID string, nextTokenID string, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	family, ok := s.families[familyID]
+	if !ok || time.Now().After(family.expiry) {
+		return fmt.Errorf("%w: unknown refresh token family", ErrInvalidToken)
+	}
+	if family.revoked {
+		return ErrRefreshTokenFamilyRevoked
+	}
+	if family.current != tokenID {
+		return ErrRefreshTokenReused
+	}
+
+	family.current = nextTokenID
+	if expiry.After(family.deadline) {
+		expiry = family.deadline
+	}
+	family.expiry = expiry
+	return nil
+}
+
+func (s *InMemoryRefreshTokenStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if family, ok := s.families[familyID]; ok {
+		family.revoked = true
+	}
+	return nil
+}
+
+// rotateFamilyScript atomically swaps the current token of a family hash, the
+// expiry (unix ms) is capped at the deadline of the family.
+var rotateFamilyScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'current')
+if not current then return -1 end
+if redis.call('HGET', KEYS[1], 'revoked') == '1' then return -2 end
+if current ~= ARGV[1] then return 0 end
+local expiry = tonumber(ARGV[3])
+local deadline = redis.call('HGET', KEYS[1], 'deadline')
+if deadline then expiry = math.min(expiry, tonumber(deadline)) end
+redis.call('HSET', KEYS[1], 'current', ARGV[2])
+redis.call('PEXPIREAT', KEYS[1], expiry)
+return 1
+`)
+
+// revokeFamilyScript only flags existing families, an expired family needs no revocation.
+var revokeFamilyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'revoked', '1')
+end
+return 1
+`)
+
+// RedisRefreshTokenStore keeps refresh token families in Redis hashes.
+type RedisRefreshTokenStore struct {
+	redisClient *redis.Client
+	prefix      string
+}
+
+// NewRedisRefreshTokenStore initializes a new RedisRefreshTokenStore, keys are prefixed with "refresh:".
+func NewRedisRefreshTokenStore(redisClient *redis.Client) *RedisRefreshTokenStore {
+	return &RedisRefreshTokenStore{
+		redisClient: redisClient,
+		prefix:      "refresh:",
+	}
+}
+
+func (s *RedisRefreshTokenStore) CreateFamily(familyID string, userID uint64, tokenID string, expiry time.Time) error {
+	ctx := context.TODO()
+	key := s.prefix + familyID
+
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user", userID, "current", tokenID, "revoked", 0, "deadline", expiry.UnixMilli())
+		pipe.ExpireAt(ctx, key, expiry)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisRefreshTokenStore) RotateFamily(familyID string, tokenID string, nextTokenID string, expiry time.Time) error {
+	result, err := rotateFamilyScript.Run(context.TODO(), s.redisClient,
+		[]string{s.prefix + familyID},
+		tokenID, nextTokenID, expiry.UnixMilli(),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	switch result {
+	case -1:
+		return fmt.Errorf("%w: unknown refresh token family", ErrInvalidToken)
+	case -2:
+		return ErrRefreshTokenFamilyRevoked
+	case 0:
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+func (s *RedisRefreshTokenStore) RevokeFamily(familyID string) error {
+	return revokeFamilyScript.Run(context.TODO(), s.redisClient, []string{s.prefix + familyID}).Err()
+}
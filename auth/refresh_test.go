@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonJWT "github.com/tphan267/common/jwt"
+)
+
+// accountLoader returns an AccountLoader reading the accounts from a map.
+func accountLoader(accounts map[uint64]*AuthTokenData) AccountLoader {
+	return func(userID uint64) (*AuthTokenData, error) {
+		return accounts[userID], nil
+	}
+}
+
+// TestRotateRefreshToken tests rotation and reuse detection of refresh tokens
+func TestRotateRefreshToken(t *testing.T) {
+	InitRefreshTokenStore(NewInMemoryRefreshTokenStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	data := &AuthTokenData{ID: 10, Name: "refresh"}
+	accounts := map[uint64]*AuthTokenData{10: data}
+	refreshToken, err := IssueRefreshToken(km, data)
+	require.NoError(t, err, "IssueRefreshToken should not return an error")
+
+	// Refresh tokens are not access tokens
+	_, err = ParseToken(km, refreshToken)
+	assert.Error(t, err, "ParseToken should reject a refresh token")
+
+	pair, err := RotateRefreshToken(km, refreshToken, accountLoader(accounts))
+	require.NoError(t, err, "RotateRefreshToken should not return an error")
+	assert.NotEqual(t, refreshToken, pair.RefreshToken, "Refresh token should be rotated")
+
+	parsedData, err := ParseToken(km, pair.AccessToken)
+	require.NoError(t, err, "ParseToken should accept the new access token")
+	assert.Equal(t, *data, *parsedData, "Parsed data should match the original data")
+
+	// Access tokens are not refresh tokens
+	_, err = RotateRefreshToken(km, pair.AccessToken, accountLoader(accounts))
+	assert.Error(t, err, "RotateRefreshToken should reject an access token")
+
+	// Reusing the rotated token revokes the whole family
+	_, err = RotateRefreshToken(km, refreshToken, accountLoader(accounts))
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	_, err = RotateRefreshToken(km, pair.RefreshToken, accountLoader(accounts))
+	assert.ErrorIs(t, err, ErrRefreshTokenFamilyRevoked, "The latest token of a revoked family should be rejected")
+}
+
+// TestRotateRefreshToken_ReloadsAccount tests that access tokens are issued for the current account
+func TestRotateRefreshToken_ReloadsAccount(t *testing.T) {
+	InitRefreshTokenStore(NewInMemoryRefreshTokenStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	accounts := map[uint64]*AuthTokenData{12: {ID: 12, Name: "user", Roles: []string{"admin"}}}
+	refreshToken, err := IssueRefreshToken(km, accounts[12])
+	require.NoError(t, err)
+
+	// The refresh token only carries the user ID
+	payload, _, err := parseRefreshToken(km, refreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, refreshTokenPayload{FamilyID: payload.FamilyID, UserID: 12}, *payload)
+
+	// Roles removed after login are not restored by the refresh
+	accounts[12] = &AuthTokenData{ID: 12, Name: "user"}
+	pair, err := RotateRefreshToken(km, refreshToken, accountLoader(accounts))
+	require.NoError(t, err)
+	data, err := ParseToken(km, pair.AccessToken)
+	require.NoError(t, err)
+	assert.Empty(t, data.Roles, "The access token should carry the current roles")
+
+	// A failing loader doesn't consume the refresh token
+	_, err = RotateRefreshToken(km, pair.RefreshToken, func(uint64) (*AuthTokenData, error) {
+		return nil, errors.New("connection refused")
+	})
+	assert.ErrorIs(t, err, ErrAuthUnavailable)
+
+	// Deleted accounts can't refresh their tokens
+	delete(accounts, 12)
+	_, err = RotateRefreshToken(km, pair.RefreshToken, accountLoader(accounts))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+// TestInMemoryRefreshTokenStore_Deadline tests that rotations don't extend a family past its deadline
+func TestInMemoryRefreshTokenStore_Deadline(t *testing.T) {
+	store := NewInMemoryRefreshTokenStore()
+	require.NoError(t, store.CreateFamily("family", 13, "first", time.Now().Add(50*time.Millisecond)))
+
+	require.NoError(t, store.RotateFamily("family", "first", "second", time.Now().Add(time.Hour)))
+	require.NoError(t, store.RotateFamily("family", "second", "third", time.Now().Add(time.Hour)))
+
+	time.Sleep(60 * time.Millisecond)
+	err := store.RotateFamily("family", "third", "fourth", time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidToken, "The family should expire at its deadline")
+}
+
+// TestRefreshTokenAPIHandler tests exchanging a refresh token through the fiber handler
+func TestRefreshTokenAPIHandler(t *testing.T) {
+	InitRefreshTokenStore(NewInMemoryRefreshTokenStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	refreshToken, err := IssueRefreshToken(km, &AuthTokenData{ID: 11})
+	require.NoError(t, err, "IssueRefreshToken should not return an error")
+
+	app := fiber.New()
+	app.Post("/auth/refresh", commonJWT.WithKeyManager(km, RefreshTokenAPIHandler(accountLoader(map[uint64]*AuthTokenData{11: {ID: 11}}))))
+
+	req := httptest.NewRequest("POST", "/auth/refresh", strings.NewReader(`{"refreshToken":"`+refreshToken+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	result := struct {
+		Success bool      `json:"success"`
+		Data    TokenPair `json:"data"`
+	}{}
+	require.NoError(t, json.Unmarshal(body, &result))
+	assert.True(t, result.Success)
+	assert.NotEmpty(t, result.Data.AccessToken)
+	assert.NotEmpty(t, result.Data.RefreshToken)
+
+	// The same refresh token cannot be exchanged twice
+	req = httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshToken)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+}
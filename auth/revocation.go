@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tphan267/common/database"
+	commonJWT "github.com/tphan267/common/jwt"
+)
+
+// RevocationStore keeps track of tokens revoked before their expiry.
+// Tokens are revoked by jti, per user or globally by issue time. Issue times
+// have a one second resolution, so tokens issued within the same second as
+// a user or global revocation are revoked too.
+type RevocationStore interface {
+	RevokeToken(jti string, expiry time.Time) error
+	RevokeUser(userID uint64, before time.Time) error
+	RevokeAllBefore(before time.Time) error
+	IsRevoked(jti string, userID uint64, issuedAt time.Time) (bool, error)
+}
+
+var revocationStore RevocationStore = NewInMemoryRevocationStore()
+
+// InitRevocationStore sets the store consulted by ParseToken. Without arguments
+// it uses database.RedisClient when initialized, an in-memory store otherwise.
+func InitRevocationStore(store ...RevocationStore) {
+	switch {
+	case len(store) > 0:
+		revocationStore = store[0]
+	case database.RedisClient != nil:
+		revocationStore = NewRedisRevocationStore(database.RedisClient)
+	default:
+		revocationStore = NewInMemoryRevocationStore()
+	}
+}
+
+// RevokeToken revokes a single token until it expires.
+func RevokeToken(keyManager *commonJWT.KeyManager, token string) error {
+	_, claims, err := keyManager.VerifyTokenClaims([]byte(token))
+	if err != nil {
+		return fmt.Errorf("failed to parse token: %w", err)
+	}
+	if claims.ID == "" {
+		return fmt.Errorf("token has no jti")
+	}
+	return revocationStore.RevokeToken(claims.ID, claims.ExpiresAt)
+}
+
+// RevokeTokenID revokes the token with the given jti until its expiry.
+func RevokeTokenID(jti string, expiry time.Time) error {
+	return revocationStore.RevokeToken(jti, expiry)
+}
+
+// RevokeUserTokens revokes all tokens issued to the user before the current
+// second, tokens issued right after the revocation are accepted.
+func RevokeUserTokens(userID uint64) error {
+	return revocationStore.RevokeUser(userID, time.Now())
+}
+
+// RevokeTokensIssuedBefore revokes all tokens issued before the given time.
+func RevokeTokensIssuedBefore(before time.Time) error {
+	return revocationStore.RevokeAllBefore(before)
+}
+
+// checkRevocation returns an error when the token has been revoked, or wrapping
+// ErrAuthUnavailable when the store could not be checked.
+func checkRevocation(claims *commonJWT.Claims, userID uint64) error {
+	revoked, err := revocationStore.IsRevoked(claims.ID, userID, claims.IssuedAt)
+	if err != nil {
+		return authUnavailable(err)
+	}
+	if revoked {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// isRevokedBefore reports whether a token issued at issuedAt is covered by a
+// revocation of all tokens issued before the given time. iat only has a one
+// second precision, so tokens issued during the second of the cut-off stay valid.
+func isRevokedBefore(issuedAt, before time.Time) bool {
+	return !before.IsZero() && issuedAt.Before(time.Unix(before.Unix(), 0))
+}
+
+// InMemoryRevocationStore is an in-memory implementation of RevocationStore.
+type InMemoryRevocationStore struct {
+	mu        sync.RWMutex
+	tokens    map[string]time.Time
+	users     map[uint64]time.Time
+	allBefore time.Time
+}
+
+// NewInMemoryRevocationStore initializes a new in-memory revocation store.
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		tokens: map[string]time.Time{},
+		users:  map[uint64]time.Time{},
+	}
+}
+
+func (s *InMemoryRevocationStore) RevokeToken(jti string, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop revoked tokens that have expired anyway
+	now := time.Now()
+	for id, exp := range s.tokens {
+		if !exp.IsZero() && now.After(exp) {
+			delete(s.tokens, id)
+		}
+	}
+
+	s.tokens[jti] = expiry
+	return nil
+}
+
+func (s *InMemoryRevocationStore) RevokeUser(userID uint64, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[userID] = before
+	return nil
+}
+
+func (s *InMemoryRevocationStore) RevokeAllBefore(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allBefore = before
+	return nil
+}
+
+func (s *InMemoryRevocationStore) IsRevoked(jti string, userID uint64, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[jti]; ok && jti != "" {
+		return true, nil
+	}
+	if isRevokedBefore(issuedAt, s.users[userID]) || isRevokedBefore(issuedAt, s.allBefore) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// RedisRevocationStore keeps revocations in Redis so they are shared by all replicas.
+type RedisRevocationStore struct {
+	redisClient *redis.Client
+	prefix      string
+}
+
+// NewRedisRevocationStore initializes a new RedisRevocationStore, keys are prefixed with "revoked:".
+func NewRedisRevocationStore(redisClient *redis.Client) *RedisRevocationStore {
+	return &RedisRevocationStore{
+		redisClient: redisClient,
+		prefix:      "revoked:",
+	}
+}
+
+func (s *RedisRevocationStore) RevokeToken(jti string, expiry time.Time) error {
+	// Keep the revocation as long as the token could be used
+	var ttl time.Duration
+	if !expiry.IsZero() {
+		ttl = time.Until(expiry)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	return s.redisClient.Set(context.TODO(), s.prefix+"jti:"+jti, 1, ttl).Err()
+}
+
+func (s *RedisRevocationStore) RevokeUser(userID uint64, before time.Time) error {
+	return s.redisClient.Set(context.TODO(), s.prefix+"user:"+strconv.FormatUint(userID, 10), before.Unix(), 0).Err()
+}
+
+func (s *RedisRevocationStore) RevokeAllBefore(before time.Time) error {
+	return s.redisClient.Set(context.TODO(), s.prefix+"all", before.Unix(), 0).Err()
+}
+
+func (s *RedisRevocationStore) IsRevoked(jti string, userID uint64, issuedAt time.Time) (bool, error) {
+	values, err := s.redisClient.MGet(context.TODO(),
+		s.prefix+"jti:"+jti,
+		s.prefix+"user:"+strconv.FormatUint(userID, 10),
+		s.prefix+"all",
+	).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+
+	if values[0] != nil && jti != "" {
+		return true, nil
+	}
+	for _, value := range values[1:] {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		before, err := strconv.ParseInt(str, 10, 64)
+		if err == nil && isRevokedBefore(issuedAt, time.Unix(before, 0)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
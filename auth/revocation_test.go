@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRevokeToken tests that a revoked token is rejected while others stay valid
+func TestRevokeToken(t *testing.T) {
+	InitRevocationStore(NewInMemoryRevocationStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	token1, err := GenerateToken(km, &AuthTokenData{ID: 1})
+	require.NoError(t, err, "GenerateToken should not return an error")
+	token2, err := GenerateToken(km, &AuthTokenData{ID: 1})
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	require.NoError(t, RevokeToken(km, *token1), "RevokeToken should not return an error")
+
+	_, err = ParseToken(km, *token1)
+	assert.Error(t, err, "ParseToken should reject a revoked token")
+
+	_, err = ParseToken(km, *token2)
+	assert.NoError(t, err, "ParseToken should accept a token that was not revoked")
+}
+
+// TestRevokeUserTokens tests revoking all tokens of a user
+func TestRevokeUserTokens(t *testing.T) {
+	InitRevocationStore(NewInMemoryRevocationStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	userToken, err := GenerateToken(km, &AuthTokenData{ID: 1})
+	require.NoError(t, err, "GenerateToken should not return an error")
+	otherToken, err := GenerateToken(km, &AuthTokenData{ID: 2})
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	// iat has a one second precision
+	time.Sleep(time.Second)
+	require.NoError(t, RevokeUserTokens(1), "RevokeUserTokens should not return an error")
+
+	_, err = ParseToken(km, *userToken)
+	assert.Error(t, err, "ParseToken should reject tokens of a revoked user")
+
+	_, err = ParseToken(km, *otherToken)
+	assert.NoError(t, err, "ParseToken should accept tokens of other users")
+
+	// Tokens issued right after the revocation are valid
+	newToken, err := GenerateToken(km, &AuthTokenData{ID: 1})
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	_, err = ParseToken(km, *newToken)
+	assert.NoError(t, err, "ParseToken should accept tokens issued after the revocation")
+}
+
+// TestRevokeTokensIssuedBefore tests revoking all tokens issued before a timestamp
+func TestRevokeTokensIssuedBefore(t *testing.T) {
+	InitRevocationStore(NewInMemoryRevocationStore())
+	defer InitRevocationStore(NewInMemoryRevocationStore())
+	km := setupKeyManager(t, 24*time.Hour)
+	defer km.Shutdown()
+
+	token, err := GenerateToken(km, &AuthTokenData{ID: 3})
+	require.NoError(t, err, "GenerateToken should not return an error")
+
+	require.NoError(t, RevokeTokensIssuedBefore(time.Now().Add(-time.Hour)))
+	_, err = ParseToken(km, *token)
+	assert.NoError(t, err, "ParseToken should accept tokens issued after the cut-off")
+
+	require.NoError(t, RevokeTokensIssuedBefore(time.Now().Add(time.Second)))
+	_, err = ParseToken(km, *token)
+	assert.Error(t, err, "ParseToken should reject tokens issued before the cut-off")
+}
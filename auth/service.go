@@ -1,23 +1,25 @@
 package auth
 
 import (
-	"errors"
+	"context"
 
 	"github.com/tphan267/common/http"
 	"github.com/tphan267/common/system"
 )
 
+// RefreshToken exchanges the token with AUTH_API, see RefreshTokenContext.
 func RefreshToken(token string) (string, error) {
-	resp := &AuthRefreshResponse{}
-	err := http.Post(system.Env("AUTH_API")+"/auth/refresh", nil, resp, map[string]string{
+	return RefreshTokenContext(context.Background(), token)
+}
+
+// RefreshTokenContext exchanges the token for a new one with AUTH_API within ctx.
+func RefreshTokenContext(ctx context.Context, token string) (string, error) {
+	newToken, _, err := http.Do[string](ctx, AuthClient, "POST", system.Env("AUTH_API")+"/auth/refresh", nil, map[string]string{
 		"Authorization": "Bearer " + token,
 	})
 	if err != nil {
-		return "", err
-	}
-	if !resp.Success {
-		return "", errors.New(resp.Error.Message)
+		return "", remoteRequestError(err)
 	}
 
-	return resp.Data, nil
+	return newToken, nil
 }
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonHttp "github.com/tphan267/common/http"
+)
+
+func TestRefreshToken_RemoteErrors(t *testing.T) {
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status == http.StatusUnauthorized {
+			w.Write([]byte(`{"success":false,"error":{"code":401,"message":"The token has expired","detail":"token_expired"}}`))
+		} else {
+			w.Write([]byte(`<html>Bad Gateway</html>`))
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("AUTH_API", server.URL)
+	defer os.Unsetenv("AUTH_API")
+
+	// Rejected tokens map to the error code sent by the auth API
+	_, err := RefreshToken("token")
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	assert.Equal(t, ErrorCodeTokenExpired, AuthErrorCode(err))
+
+	// Outages are not authentication failures
+	status = http.StatusBadGateway
+	_, err = RefreshToken("token")
+	assert.ErrorIs(t, err, ErrAuthUnavailable)
+	var httpErr *commonHttp.HTTPError
+	require.True(t, errors.As(err, &httpErr), "The HTTPError should be wrapped")
+	assert.Equal(t, http.StatusBadGateway, httpErr.StatusCode)
+	assert.Nil(t, httpErr.ApiError)
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return ErrorAuthResp(c, err)
+	})
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+}
+
+func TestRefreshToken_Envelope(t *testing.T) {
+	body := `{"success":true,"data":"new-token"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	os.Setenv("AUTH_API", server.URL)
+	defer os.Unsetenv("AUTH_API")
+
+	token, err := RefreshToken("token")
+	require.NoError(t, err)
+	assert.Equal(t, "new-token", token)
+
+	// Unsuccessful responses are rejections, even with a 2xx status
+	body = `{"success":false,"error":{"code":401,"message":"The token was revoked","detail":"token_revoked"}}`
+	_, err = RefreshToken("token")
+	assert.ErrorIs(t, err, ErrRevoked)
+	assert.NotErrorIs(t, err, ErrAuthUnavailable)
+}
+
+func TestRefreshToken_CircuitOpen(t *testing.T) {
+	// Restore the client after the environment
+	t.Cleanup(func() { InitAuthClient() })
+	t.Setenv("AUTH_API_BREAKER_THRESHOLD", "5")
+	InitAuthClient()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	os.Setenv("AUTH_API", server.URL)
+	defer os.Unsetenv("AUTH_API")
+
+	for range 5 {
+		_, err := RefreshToken("token")
+		assert.ErrorIs(t, err, ErrAuthUnavailable)
+	}
+
+	// Requests fail fast while the breaker is open
+	_, err := RefreshToken("token")
+	assert.ErrorIs(t, err, ErrAuthUnavailable)
+	assert.ErrorIs(t, err, commonHttp.ErrCircuitOpen)
+	assert.Equal(t, 5, calls)
+}
+
+func TestRefreshTokenContext_Timeouts(t *testing.T) {
+	calls := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	t.Cleanup(func() { InitAuthClient() })
+	t.Setenv("AUTH_API", server.URL)
+	t.Setenv("AUTH_API_TIMEOUT", "1s")
+	InitAuthClient()
+
+	// Slow auth services fail requests within AUTH_API_TIMEOUT
+	start := time.Now()
+	_, err := RefreshToken("token")
+	assert.ErrorIs(t, err, ErrAuthUnavailable)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.EqualValues(t, 1, calls.Load(), "POST requests should not be retried")
+
+	// Requests end with the context of the caller
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = RefreshTokenContext(ctx, "token")
+	assert.ErrorIs(t, err, ErrAuthUnavailable)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestInitAuthClient(t *testing.T) {
+	calls := atomic.Int32{}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-release
+		}
+		w.Write([]byte(`{"success":true,"data":"new-token"}`))
+	}))
+	defer server.Close()
+
+	t.Cleanup(func() { InitAuthClient() })
+	t.Setenv("AUTH_API", server.URL)
+	t.Setenv("AUTH_API_MAX_CONCURRENT", "1")
+	t.Setenv("AUTH_API_QUEUE_WAIT", "0s")
+	InitAuthClient()
+
+	done := make(chan error)
+	go func() {
+		_, err := RefreshToken("token")
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	// AUTH_API_MAX_CONCURRENT requests are in flight
+	_, err := RefreshToken("token")
+	assert.ErrorIs(t, err, ErrAuthUnavailable)
+	assert.ErrorIs(t, err, commonHttp.ErrBulkheadFull)
+
+	close(release)
+	assert.NoError(t, <-done)
+	token, err := RefreshToken("token")
+	require.NoError(t, err)
+	assert.Equal(t, "new-token", token)
+}
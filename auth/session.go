@@ -0,0 +1,447 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/tphan267/common/api"
+	"github.com/tphan267/common/cache"
+	"github.com/tphan267/common/system"
+	"github.com/tphan267/common/utils"
+)
+
+const (
+	// CSRFHeader carries the CSRF token of the session on unsafe requests.
+	CSRFHeader = "X-CSRF-Token"
+	// ErrorCodeInvalidCSRFToken is the error code of requests rejected by CSRFMiddleware.
+	ErrorCodeInvalidCSRFToken = "invalid_csrf_token"
+
+	sessionIDLength = 32
+	csrfTokenLength = 32
+	// sessionTouchInterval bounds the writes sliding the expiration of sessions.
+	sessionTouchInterval = time.Minute
+	// sessionIndexTTL is the minimum expiration of the per-user session indexes
+	// of RedisSessionStore, it must outlive the sessions it lists.
+	sessionIndexTTL = 31 * 24 * time.Hour
+)
+
+// Session is a server-side session of a browser, identified by the cookie.
+type Session struct {
+	ID         string         `json:"id"`
+	Account    *AuthTokenData `json:"account"`
+	CSRFToken  string         `json:"csrfToken"`
+	UserAgent  string         `json:"userAgent,omitempty"`
+	IP         string         `json:"ip,omitempty"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	LastSeenAt time.Time      `json:"lastSeenAt"`
+}
+
+// SessionStore persists sessions. Expired sessions must not be returned.
+type SessionStore interface {
+	// SaveSession creates or replaces the session, it expires after ttl.
+	SaveSession(session *Session, ttl time.Duration) error
+	// GetSession returns nil without error when the session does not exist.
+	GetSession(id string) (*Session, error)
+	DeleteSession(session *Session) error
+	ListSessions(userID uint64) ([]*Session, error)
+}
+
+// SessionConfig configures a SessionManager.
+type SessionConfig struct {
+	// CookieName defaults to "session_id".
+	CookieName   string
+	CookieDomain string
+	// CookiePath defaults to "/".
+	CookiePath string
+	// CookieSameSite defaults to "Lax".
+	CookieSameSite string
+	// InsecureCookie allows sending the cookie over plain HTTP, e.g. in development.
+	InsecureCookie bool
+	// IdleTimeout expires sessions unused for that long, defaults to the
+	// SESSION_IDLE_TIMEOUT env or 24h.
+	IdleTimeout time.Duration
+	// MaxLifetime expires sessions that long after their creation, defaults to
+	// the SESSION_MAX_LIFETIME env or 30 days.
+	MaxLifetime time.Duration
+}
+
+// SessionManager authenticates browser requests with a session cookie.
+type SessionManager struct {
+	store  SessionStore
+	config SessionConfig
+}
+
+// NewSessionManager creates a SessionManager. Without store it uses the cache
+// initialized by cache.InitRedisCache, an in-memory store otherwise.
+func NewSessionManager(store SessionStore, config ...SessionConfig) *SessionManager {
+	if store == nil {
+		if redisCache := cache.Default(); redisCache != nil {
+			store = NewRedisSessionStore(redisCache)
+		} else {
+			store = NewInMemorySessionStore()
+		}
+	}
+
+	cfg := SessionConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "session_id"
+	}
+	if cfg.CookiePath == "" {
+		cfg.CookiePath = "/"
+	}
+	if cfg.CookieSameSite == "" {
+		cfg.CookieSameSite = fiber.CookieSameSiteLaxMode
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout, _ = utils.ParseDuration(system.Env("SESSION_IDLE_TIMEOUT", "24h"))
+	}
+	if cfg.MaxLifetime <= 0 {
+		cfg.MaxLifetime, _ = utils.ParseDuration(system.Env("SESSION_MAX_LIFETIME", "30D"))
+	}
+
+	return &SessionManager{store: store, config: cfg}
+}
+
+// CreateSession starts a session for the account, e.g. at login, and sets the
+// session cookie. Any session of the request is destroyed first.
+func (m *SessionManager) CreateSession(c *fiber.Ctx, act *AuthTokenData) (*Session, error) {
+	if err := m.DestroySession(c); err != nil {
+		return nil, err
+	}
+
+	id, err := utils.GenerateRandomString(sessionIDLength)
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := utils.GenerateRandomString(csrfTokenLength)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:         id,
+		Account:    act,
+		CSRFToken:  csrfToken,
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	if err := m.store.SaveSession(session, m.ttl(session)); err != nil {
+		return nil, err
+	}
+
+	m.setCookie(c, id, session.CreatedAt.Add(m.config.MaxLifetime))
+	c.Locals("session", session)
+	return session, nil
+}
+
+// DestroySession deletes the session of the request, e.g. at logout, and clears the cookie.
+func (m *SessionManager) DestroySession(c *fiber.Ctx) error {
+	id := c.Cookies(m.config.CookieName)
+	if id == "" {
+		return nil
+	}
+
+	session, err := m.store.GetSession(id)
+	if err != nil {
+		return err
+	}
+	if session != nil {
+		if err := m.store.DeleteSession(session); err != nil {
+			return err
+		}
+	}
+
+	m.setCookie(c, "", time.Unix(0, 0))
+	return nil
+}
+
+// ListSessions returns the active sessions of the user, e.g. for a "devices" page.
+func (m *SessionManager) ListSessions(userID uint64) ([]*Session, error) {
+	return m.store.ListSessions(userID)
+}
+
+// KillSession deletes a session of the user, sessions of other users are left untouched.
+func (m *SessionManager) KillSession(userID uint64, sessionID string) error {
+	session, err := m.store.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil || session.Account == nil || session.Account.ID != userID {
+		return nil
+	}
+	return m.store.DeleteSession(session)
+}
+
+// KillUserSessions deletes all the sessions of the user, e.g. after a password change.
+func (m *SessionManager) KillUserSessions(userID uint64) error {
+	sessions, err := m.store.ListSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := m.store.DeleteSession(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Middleware authenticates requests with the session cookie and stores the
+// account in ctx.Locals like the token middlewares, the session is stored in
+// ctx.Locals("session"). The expiration of the session slides with its use.
+func (m *SessionManager) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Cookies(m.config.CookieName)
+		if id == "" {
+			return ErrorAuthResp(c, ErrMissingToken)
+		}
+
+		session, err := m.store.GetSession(id)
+		if err != nil {
+			return ErrorAuthResp(c, authUnavailable(err))
+		}
+		now := time.Now()
+		if session == nil || session.Account == nil || now.After(session.CreatedAt.Add(m.config.MaxLifetime)) {
+			m.setCookie(c, "", time.Unix(0, 0))
+			return ErrorAuthResp(c, ErrSessionExpired)
+		}
+
+		if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+			session.LastSeenAt = now
+			if err := m.store.SaveSession(session, m.ttl(session)); err != nil {
+				system.Logger.Errorf("Error extending session: %v", err)
+			}
+		}
+
+		setAccountLocals(c, "", session.Account)
+		c.Locals("session", session)
+
+		return c.Next()
+	}
+}
+
+// CSRFMiddleware rejects unsafe requests whose X-CSRF-Token header or "_csrf"
+// form value doesn't match the CSRF token of the session. It must be used
+// after SessionManager.Middleware.
+func CSRFMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, fiber.MethodTrace:
+			return c.Next()
+		}
+
+		session, _ := c.Locals("session").(*Session)
+		if session == nil {
+			return ErrorAuthResp(c, ErrMissingToken)
+		}
+
+		token := c.Get(CSRFHeader)
+		if token == "" {
+			token = c.FormValue("_csrf")
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+			return api.ErrorResp(c, api.ApiError{
+				Code:    fiber.StatusForbidden,
+				Message: "Invalid CSRF token",
+				Detail:  ErrorCodeInvalidCSRFToken,
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// CSRFToken returns the CSRF token of the session of the request, to be sent
+// back by the page in the X-CSRF-Token header or the "_csrf" form value.
+func CSRFToken(c *fiber.Ctx) string {
+	if session, ok := c.Locals("session").(*Session); ok {
+		return session.CSRFToken
+	}
+	return ""
+}
+
+// ttl returns the idle timeout of the session, bounded by its max lifetime.
+func (m *SessionManager) ttl(session *Session) time.Duration {
+	return min(m.config.IdleTimeout, time.Until(session.CreatedAt.Add(m.config.MaxLifetime)))
+}
+
+func (m *SessionManager) setCookie(c *fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     m.config.CookieName,
+		Value:    value,
+		Path:     m.config.CookiePath,
+		Domain:   m.config.CookieDomain,
+		Expires:  expires,
+		Secure:   !m.config.InsecureCookie,
+		HTTPOnly: true,
+		SameSite: m.config.CookieSameSite,
+	})
+}
+
+type sessionEntry struct {
+	session *Session
+	expiry  time.Time
+}
+
+// InMemorySessionStore is an in-memory implementation of SessionStore.
+type InMemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]sessionEntry
+}
+
+// NewInMemorySessionStore initializes a new in-memory session store.
+func NewInMemorySessionStore() *InMemorySessionStore {
+	return &InMemorySessionStore{
+		sessions: map[string]sessionEntry{},
+	}
+}
+
+func (s *InMemorySessionStore) SaveSession(session *Session, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired sessions
+	now := time.Now()
+	for id, entry := range s.sessions {
+		if now.After(entry.expiry) {
+			delete(s.sessions, id)
+		}
+	}
+
+	stored := *session
+	s.sessions[session.ID] = sessionEntry{session: &stored, expiry: now.Add(ttl)}
+	return nil
+}
+
+func (s *InMemorySessionStore) GetSession(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.sessions[id]
+	if !ok || time.Now().After(entry.expiry) {
+		return nil, nil
+	}
+	session := *entry.session
+	return &session, nil
+}
+
+func (s *InMemorySessionStore) DeleteSession(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, session.ID)
+	return nil
+}
+
+func (s *InMemorySessionStore) ListSessions(userID uint64) ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var sessions []*Session
+	for _, entry := range s.sessions {
+		if entry.session.Account != nil && entry.session.Account.ID == userID && !now.After(entry.expiry) {
+			session := *entry.session
+			sessions = append(sessions, &session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return sessions, nil
+}
+
+// RedisSessionStore keeps sessions in Redis through the cache package. Each
+// user has a set indexing the IDs of its sessions.
+type RedisSessionStore struct {
+	cache  *cache.RedisCache
+	prefix string
+}
+
+// NewRedisSessionStore initializes a new RedisSessionStore, keys are prefixed with "session:".
+func NewRedisSessionStore(redisCache *cache.RedisCache) *RedisSessionStore {
+	return &RedisSessionStore{
+		cache:  redisCache,
+		prefix: "session:",
+	}
+}
+
+func (s *RedisSessionStore) SaveSession(session *Session, ttl time.Duration) error {
+	if err := s.cache.SetObj(s.prefix+session.ID, session, ttl); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	// Expired sessions are removed from the index by ListSessions
+	userKey := s.userKey(session.Account.ID)
+	if err := s.cache.SAdd(userKey, session.ID); err != nil {
+		return fmt.Errorf("failed to index session: %w", err)
+	}
+	return s.cache.Expire(userKey, max(ttl, sessionIndexTTL))
+}
+
+func (s *RedisSessionStore) GetSession(id string) (*Session, error) {
+	session := &Session{}
+	err := s.cache.GetObj(s.prefix+id, session)
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+	return session, nil
+}
+
+func (s *RedisSessionStore) DeleteSession(session *Session) error {
+	if err := s.cache.Del(s.prefix + session.ID); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	if session.Account != nil {
+		return s.cache.SRem(s.userKey(session.Account.ID), session.ID)
+	}
+	return nil
+}
+
+func (s *RedisSessionStore) ListSessions(userID uint64) ([]*Session, error) {
+	ids, err := s.cache.SMembers(s.userKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	var sessions []*Session
+	var expired []string
+	for _, id := range ids {
+		session, err := s.GetSession(id)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil {
+			expired = append(expired, id)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if len(expired) > 0 {
+		_ = s.cache.SRem(s.userKey(userID), expired...)
+	}
+
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (s *RedisSessionStore) userKey(userID uint64) string {
+	return s.prefix + "user:" + strconv.FormatUint(userID, 10)
+}
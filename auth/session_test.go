@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSessionApp(t *testing.T, sessions *SessionManager) *fiber.App {
+	app := fiber.New()
+	app.Post("/login", func(c *fiber.Ctx) error {
+		session, err := sessions.CreateSession(c, &AuthTokenData{ID: 8, Name: "Jane"})
+		require.NoError(t, err)
+		return c.SendString(session.CSRFToken)
+	})
+	app.Post("/logout", func(c *fiber.Ctx) error {
+		return sessions.DestroySession(c)
+	})
+
+	protected := app.Group("/app", sessions.Middleware(), CSRFMiddleware())
+	protected.Get("/me", func(c *fiber.Ctx) error {
+		return c.SendString(Account(c).Name)
+	})
+	protected.Post("/orders", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusCreated)
+	})
+	return app
+}
+
+func login(t *testing.T, app *fiber.App) (*http.Cookie, string) {
+	resp, err := app.Test(httptest.NewRequest("POST", "/login", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	cookies := resp.Cookies()
+	require.Len(t, cookies, 1)
+	csrfToken, _ := io.ReadAll(resp.Body)
+	return cookies[0], string(csrfToken)
+}
+
+func TestSessionManager(t *testing.T) {
+	sessions := NewSessionManager(NewInMemorySessionStore(), SessionConfig{IdleTimeout: time.Hour})
+	app := setupSessionApp(t, sessions)
+
+	cookie, csrfToken := login(t, app)
+	assert.Equal(t, "session_id", cookie.Name)
+	assert.True(t, cookie.HttpOnly, "Session cookie must be HttpOnly")
+	assert.True(t, cookie.Secure, "Session cookie must be Secure")
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	request := func(method, path string, headers map[string]string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(cookie)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusOK, request("GET", "/app/me", nil))
+
+	// Unsafe requests need the CSRF token of the session
+	assert.Equal(t, fiber.StatusForbidden, request("POST", "/app/orders", nil))
+	assert.Equal(t, fiber.StatusForbidden, request("POST", "/app/orders", map[string]string{CSRFHeader: strings.Repeat("x", csrfTokenLength)}))
+	assert.Equal(t, fiber.StatusCreated, request("POST", "/app/orders", map[string]string{CSRFHeader: csrfToken}))
+
+	// Requests without session are rejected
+	resp, err := app.Test(httptest.NewRequest("GET", "/app/me", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+
+	// Logout destroys the session
+	assert.Equal(t, fiber.StatusOK, request("POST", "/logout", nil))
+	assert.Equal(t, fiber.StatusUnauthorized, request("GET", "/app/me", nil))
+}
+
+func TestSessionManager_KillSessions(t *testing.T) {
+	sessions := NewSessionManager(NewInMemorySessionStore(), SessionConfig{IdleTimeout: time.Hour})
+	app := setupSessionApp(t, sessions)
+
+	first, _ := login(t, app)
+	second, _ := login(t, app)
+
+	active, err := sessions.ListSessions(8)
+	require.NoError(t, err)
+	require.Len(t, active, 2)
+	assert.Equal(t, first.Value, active[0].ID)
+
+	me := func(cookie *http.Cookie) int {
+		req := httptest.NewRequest("GET", "/app/me", nil)
+		req.AddCookie(cookie)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// Sessions can only be killed by their owner
+	require.NoError(t, sessions.KillSession(9, first.Value))
+	assert.Equal(t, fiber.StatusOK, me(first))
+
+	require.NoError(t, sessions.KillSession(8, first.Value))
+	assert.Equal(t, fiber.StatusUnauthorized, me(first))
+	assert.Equal(t, fiber.StatusOK, me(second))
+
+	require.NoError(t, sessions.KillUserSessions(8))
+	assert.Equal(t, fiber.StatusUnauthorized, me(second))
+
+	active, err = sessions.ListSessions(8)
+	require.NoError(t, err)
+	assert.Empty(t, active)
+}
+
+func TestSessionManager_IdleTimeout(t *testing.T) {
+	sessions := NewSessionManager(NewInMemorySessionStore(), SessionConfig{IdleTimeout: 50 * time.Millisecond})
+	app := setupSessionApp(t, sessions)
+
+	cookie, _ := login(t, app)
+	time.Sleep(100 * time.Millisecond)
+
+	req := httptest.NewRequest("GET", "/app/me", nil)
+	req.AddCookie(cookie)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+}
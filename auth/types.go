@@ -12,15 +12,20 @@ type AuthTokenData struct {
 	Email     string        `json:"email" gorm:"type:varchar(128);uniqueIndex"`
 	AvatarUrl string        `json:"avatarUrl" gorm:"type:varchar(256)"`
 	IsAdmin   bool          `json:"isAdmin"`
+	TenantID  uint64        `json:"tenantId,omitempty" gorm:"index"`
+	Roles     []string      `json:"roles,omitempty" gorm:"serializer:json"`
+	Scopes    []string      `json:"scopes,omitempty" gorm:"serializer:json"`
 	Meta      *types.Params `json:"meta,omitempty"`
 }
 
+// Deprecated: use http.Do[*AuthTokenData], which decodes the response envelope.
 type AuthValidateResponse struct {
 	Success bool           `json:"success"`
 	Data    *AuthTokenData `json:"data,omitempty"`
 	Error   *api.ApiError  `json:"error,omitempty"`
 }
 
+// Deprecated: use http.Do[string], which decodes the response envelope.
 type AuthRefreshResponse struct {
 	Success bool          `json:"success"`
 	Data    string        `json:"data,omitempty"`
@@ -112,3 +112,52 @@ func (ins *RedisCache) getExpiration(expiration ...time.Duration) time.Duration
 
 	return ins.defaultExpiration
 }
+
+// Expire set the expiration of a key
+func Expire(key string, expiration time.Duration) error {
+	return instance.Expire(key, expiration)
+}
+
+// SAdd add members to a set
+func SAdd(key string, members ...string) error {
+	return instance.SAdd(key, members...)
+}
+
+// SRem remove members from a set
+func SRem(key string, members ...string) error {
+	return instance.SRem(key, members...)
+}
+
+// SMembers get the members of a set
+func SMembers(key string) ([]string, error) {
+	return instance.SMembers(key)
+}
+
+// Default returns the cache initialized by InitRedisCache, nil when not initialized
+func Default() *RedisCache {
+	return instance
+}
+
+func (ins *RedisCache) Expire(key string, expiration time.Duration) error {
+	return ins.redisClient.Expire(context.TODO(), key, expiration).Err()
+}
+
+func (ins *RedisCache) SAdd(key string, members ...string) error {
+	values := make([]any, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return ins.redisClient.SAdd(context.TODO(), key, values...).Err()
+}
+
+func (ins *RedisCache) SRem(key string, members ...string) error {
+	values := make([]any, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return ins.redisClient.SRem(context.TODO(), key, values...).Err()
+}
+
+func (ins *RedisCache) SMembers(key string) ([]string, error) {
+	return ins.redisClient.SMembers(context.TODO(), key).Result()
+}
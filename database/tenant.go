@@ -0,0 +1,37 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrMissingTenant is added to queries scoped by TenantScope on requests without tenant.
+var ErrMissingTenant = errors.New("missing tenant")
+
+// TenantScope filters queries on the tenant_id column of the current table by
+// the tenant of the authenticated account, as stored in ctx.Locals("tenantID")
+// by the auth middlewares. It fails closed: queries of requests without tenant
+// return ErrMissingTenant. Combine with the other scopes, e.g.
+// db.Scopes(TenantScope(c), Ordering(c, meta), Paginate(c, pagination)).
+func TenantScope(c *fiber.Ctx) func(db *gorm.DB) *gorm.DB {
+	tenantID, _ := c.Locals("tenantID").(uint64)
+	return TenantIDScope(tenantID)
+}
+
+// TenantIDScope filters queries by the given tenant, e.g. in background jobs.
+// A zero tenantID fails the query with ErrMissingTenant.
+func TenantIDScope(tenantID uint64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if tenantID == 0 {
+			db.AddError(ErrMissingTenant)
+			return db
+		}
+		return db.Where(clause.Eq{
+			Column: clause.Column{Table: clause.CurrentTable, Name: "tenant_id"},
+			Value:  tenantID,
+		})
+	}
+}
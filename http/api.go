@@ -0,0 +1,103 @@
+package http
+
+import (
+	"context"
+	"iter"
+	"net/url"
+	"strconv"
+
+	"github.com/tphan267/common/api"
+)
+
+// apiResponse is the api.ApiResponse envelope with typed data.
+type apiResponse[T any] struct {
+	Success bool                 `json:"success"`
+	Data    T                    `json:"data"`
+	Error   *api.ApiError        `json:"error"`
+	Meta    *api.ApiResponseMeta `json:"meta"`
+}
+
+// Do sends data JSON encoded with the client, DefaultClient when nil, and
+// decodes the api.ApiResponse envelope of the response. It returns the data and
+// the meta of the envelope, meta is nil when missing. Unsuccessful responses
+// are returned as *HTTPError carrying the ApiError, even with a 2xx status.
+func Do[T any](ctx context.Context, client *Client, method string, url string, data any, headers map[string]string) (T, *api.ApiResponseMeta, error) {
+	if client == nil {
+		client = DefaultClient
+	}
+
+	var zero T
+	envelope := apiResponse[T]{}
+	resp, err := client.sendJSON(ctx, method, url, data, &envelope, headers)
+	if err != nil {
+		return zero, nil, err
+	}
+
+	if !envelope.Success {
+		httpErr := newHTTPError(method, url, resp)
+		if httpErr.ApiError == nil {
+			httpErr.ApiError = &api.ApiError{Message: "unsuccessful response"}
+		}
+		return zero, envelope.Meta, httpErr
+	}
+
+	return envelope.Data, envelope.Meta, nil
+}
+
+// Pages iterates over the items of a paginated endpoint returning a list, as
+// produced with database.Paginate. Pages are requested with the "page" query
+// parameter until Pagination.TotalPages, or until a page is empty when the
+// response has no pagination. The iteration stops after yielding an error.
+func Pages[T any](ctx context.Context, client *Client, pageURL string, headers map[string]string) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		target, err := url.Parse(pageURL)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		query := target.Query()
+		page := 1
+		if value := query.Get("page"); value != "" {
+			if page, err = strconv.Atoi(value); err != nil {
+				yield(zero, err)
+				return
+			}
+		}
+
+		for {
+			query.Set("page", strconv.Itoa(page))
+			target.RawQuery = query.Encode()
+
+			items, meta, err := Do[[]T](ctx, client, "GET", target.String(), nil, cloneHeaders(headers))
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if len(items) == 0 {
+				return
+			}
+			if meta != nil && meta.Pagination != nil && page >= meta.Pagination.TotalPages {
+				return
+			}
+			page++
+		}
+	}
+}
+
+// cloneHeaders copies the headers, requests set their Content-Type in the map.
+func cloneHeaders(headers map[string]string) map[string]string {
+	cloned := make(map[string]string, len(headers))
+	for key, val := range headers {
+		cloned[key] = val
+	}
+	return cloned
+}
@@ -1,7 +1,7 @@
 package http
 
 import (
-	"bytes"
+	"context"
 	"net/url"
 )
 
@@ -15,10 +15,5 @@ import (
 // Returns:
 //   - error if any occurred (including non-2xx status codes)
 func PostForm(url string, data url.Values, out any, headers map[string]string) error {
-	if headers == nil {
-		headers = map[string]string{}
-	}
-	headers["Content-Type"] = "application/x-www-form-urlencoded"
-	in := bytes.NewBufferString(data.Encode())
-	return httpRequest("POST", url, in, out, headers)
+	return DefaultClient.PostForm(context.Background(), url, data, out, headers)
 }
@@ -0,0 +1,22 @@
+package http
+
+import (
+	"context"
+	"io"
+)
+
+// PostMultipart sends fields and files as multipart/form-data, streaming the
+// files, and decodes the JSON response into out.
+func PostMultipart(url string, fields map[string]string, files []FilePart, out any, headers map[string]string) error {
+	return DefaultClient.PostMultipart(context.Background(), url, fields, files, out, headers)
+}
+
+// Download streams the body of a GET response to w, progress may be nil.
+func Download(url string, w io.Writer, headers map[string]string, progress ProgressFunc) (int64, error) {
+	return DefaultClient.Download(context.Background(), url, w, headers, progress)
+}
+
+// DownloadFile streams the body of a GET response to the file at path, progress may be nil.
+func DownloadFile(url string, path string, headers map[string]string, progress ProgressFunc) (int64, error) {
+	return DefaultClient.DownloadFile(context.Background(), url, path, headers, progress)
+}
@@ -0,0 +1,232 @@
+package http
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	netHttp "net/http"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testItem struct {
+	Name string `json:"name"`
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		want      *testItem
+		requestID string
+		errStatus int
+		errMsg    string
+	}{
+		{
+			name:   "success",
+			status: netHttp.StatusOK,
+			body:   `{"success":true,"data":{"name":"ok"}}`,
+			want:   &testItem{Name: "ok"},
+		},
+		{
+			name:      "meta",
+			status:    netHttp.StatusOK,
+			body:      `{"success":true,"data":{"name":"ok"},"meta":{"requestId":"req-1","pagination":{"page":1,"totalPages":3}}}`,
+			want:      &testItem{Name: "ok"},
+			requestID: "req-1",
+		},
+		{
+			name:      "unsuccessful 2xx",
+			status:    netHttp.StatusOK,
+			body:      `{"success":false,"error":{"code":409,"message":"Already exists"},"meta":{"requestId":"req-2"}}`,
+			requestID: "req-2",
+			errStatus: netHttp.StatusOK,
+			errMsg:    "Already exists",
+		},
+		{
+			name:      "unsuccessful 2xx without error",
+			status:    netHttp.StatusOK,
+			body:      `{"success":false}`,
+			errStatus: netHttp.StatusOK,
+			errMsg:    "unsuccessful response",
+		},
+		{
+			name:      "non-2xx",
+			status:    netHttp.StatusNotFound,
+			body:      `{"success":false,"error":{"code":404,"message":"Not found"}}`,
+			errStatus: netHttp.StatusNotFound,
+			errMsg:    "Not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			data, meta, err := Do[*testItem](context.Background(), testClient(ClientOptions{}), "GET", server.URL, nil, nil)
+			if tt.errStatus != 0 {
+				var httpErr *HTTPError
+				require.ErrorAs(t, err, &httpErr)
+				assert.Equal(t, tt.errStatus, httpErr.StatusCode)
+				require.NotNil(t, httpErr.ApiError)
+				assert.Equal(t, tt.errMsg, httpErr.ApiError.Message)
+				assert.Nil(t, data)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, data)
+			}
+
+			if tt.requestID == "" {
+				assert.Nil(t, meta)
+				return
+			}
+			require.NotNil(t, meta, "The meta of the envelope should be returned")
+			assert.Equal(t, tt.requestID, meta.RequestID)
+		})
+	}
+}
+
+// pageServer serves the items of each page in the envelope with the given
+// total pages, without pagination when zero. It returns the requested pages.
+func pageServer(t *testing.T, pages map[int]string, totalPages int) (string, func() []string) {
+	var mu sync.Mutex
+	var requested []string
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		query := r.URL.Query()
+		mu.Lock()
+		requested = append(requested, query.Get("page"))
+		mu.Unlock()
+
+		page, _ := strconv.Atoi(query.Get("page"))
+		items, ok := pages[page]
+		if !ok {
+			items = "[]"
+		}
+		meta := ""
+		if totalPages > 0 {
+			meta = `,"meta":{"pagination":{"page":` + strconv.Itoa(page) + `,"totalPages":` + strconv.Itoa(totalPages) + `}}`
+		}
+		w.Write([]byte(`{"success":true,"data":` + items + meta + `}`))
+	})
+
+	return server.URL, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requested...)
+	}
+}
+
+func TestPages(t *testing.T) {
+	pages := map[int]string{
+		1: `[{"name":"a"},{"name":"b"}]`,
+		2: `[{"name":"c"}]`,
+		3: `[{"name":"d"}]`,
+		4: `[{"name":"e"}]`,
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		totalPages int
+		limit      int
+		want       []string
+		requested  []string
+	}{
+		{
+			name:       "stops at total pages",
+			totalPages: 2,
+			want:       []string{"a", "b", "c"},
+			requested:  []string{"1", "2"},
+		},
+		{
+			name:      "stops on an empty page",
+			want:      []string{"a", "b", "c", "d", "e"},
+			requested: []string{"1", "2", "3", "4", "5"},
+		},
+		{
+			name:       "starts at the page query parameter",
+			query:      "?page=3&status=active",
+			totalPages: 4,
+			want:       []string{"d", "e"},
+			requested:  []string{"3", "4"},
+		},
+		{
+			name:       "early break",
+			totalPages: 4,
+			limit:      1,
+			want:       []string{"a"},
+			requested:  []string{"1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverURL, requested := pageServer(t, pages, tt.totalPages)
+
+			var names []string
+			for item, err := range Pages[testItem](context.Background(), testClient(ClientOptions{}), serverURL+tt.query, nil) {
+				require.NoError(t, err)
+				names = append(names, item.Name)
+				if tt.limit > 0 && len(names) == tt.limit {
+					break
+				}
+			}
+
+			assert.Equal(t, tt.want, names)
+			assert.Equal(t, tt.requested, requested())
+		})
+	}
+}
+
+func TestPages_KeepsQuery(t *testing.T) {
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		assert.Equal(t, "active", r.URL.Query().Get("status"))
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		w.Write([]byte(`{"success":true,"data":[]}`))
+	})
+
+	for _, err := range Pages[testItem](context.Background(), testClient(ClientOptions{}), server.URL+"?status=active", map[string]string{"X-Api-Key": "secret"}) {
+		require.NoError(t, err)
+	}
+}
+
+func TestPages_Errors(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		if r.URL.Query().Get("page") == "1" {
+			w.Write([]byte(`{"success":true,"data":[{"name":"a"}],"meta":{"pagination":{"page":1,"totalPages":3}}}`))
+			return
+		}
+		w.WriteHeader(netHttp.StatusNotFound)
+		w.Write([]byte(`{"success":false,"error":{"code":404,"message":"Not found"}}`))
+	})
+
+	var names []string
+	var errs []error
+	for item, err := range Pages[testItem](context.Background(), testClient(ClientOptions{}), server.URL, nil) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		names = append(names, item.Name)
+	}
+	assert.Equal(t, []string{"a"}, names)
+	require.Len(t, errs, 1, "The iteration should stop after an error")
+	var httpErr *HTTPError
+	require.ErrorAs(t, errs[0], &httpErr)
+	assert.Equal(t, netHttp.StatusNotFound, httpErr.StatusCode)
+	assert.EqualValues(t, 2, count.Load())
+
+	// Invalid page parameters are reported before sending requests
+	count.Store(0)
+	for _, err := range Pages[testItem](context.Background(), testClient(ClientOptions{}), server.URL+"?page=first", nil) {
+		assert.Error(t, err)
+	}
+	assert.Zero(t, count.Load())
+}
@@ -0,0 +1,211 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCircuitOpen is returned without sending the request while the circuit
+	// breaker of the host is open.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrBulkheadFull is returned without sending the request when the host has
+	// MaxConcurrentPerHost requests in flight.
+	ErrBulkheadFull = errors.New("too many concurrent requests")
+)
+
+// BreakerState is the state of the circuit breaker of a host.
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through, counting consecutive failures.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests fast until OpenTimeout elapsed.
+	BreakerOpen
+	// BreakerHalfOpen lets HalfOpenRequests probe requests through, closing the
+	// breaker when one succeeds and opening it again when one fails.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// BreakerOptions configures the circuit breakers of a Client, zero values use the defaults.
+type BreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures, network errors
+	// and 5xx or 429 responses, opening the breaker. Defaults to 0, the breaker
+	// is disabled.
+	FailureThreshold int
+	// OpenTimeout is the time the breaker stays open before letting probe
+	// requests through, defaults to 30s.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of concurrent probe requests, defaults to 1.
+	HalfOpenRequests int
+}
+
+// breaker is the circuit breaker of a host.
+type breaker struct {
+	options BreakerOptions
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+// allow reports whether a request may be sent, probe is true for the probe
+// requests of a half-open breaker.
+func (b *breaker) allow() (probe bool, err error) {
+	if b.options.FailureThreshold <= 0 {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.options.OpenTimeout {
+			return false, ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probes = 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.options.HalfOpenRequests {
+			return false, ErrCircuitOpen
+		}
+		b.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+// record records the outcome of a request let through by allow. Requests
+// started in another state than the current one are ignored.
+func (b *breaker) record(probe bool, success bool) {
+	if b.options.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case probe && b.state == BreakerHalfOpen:
+		b.probes--
+		if success {
+			b.state = BreakerClosed
+			b.failures = 0
+		} else {
+			b.open()
+		}
+	case !probe && b.state == BreakerClosed:
+		if success {
+			b.failures = 0
+		} else if b.failures++; b.failures >= b.options.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+// cancel releases the probe slot of a request whose outcome tells nothing
+// about the host, e.g. canceled by the caller.
+func (b *breaker) cancel(probe bool) {
+	if !probe || b.options.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probes--
+	}
+}
+
+func (b *breaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.failures = 0
+}
+
+func (b *breaker) currentState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.options.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// upstream holds the circuit breaker and the bulkhead of a host.
+type upstream struct {
+	breaker *breaker
+	// slots limits the concurrent requests, nil when unlimited.
+	slots chan struct{}
+}
+
+// acquire takes a request slot, waiting at most MaxQueueWait for one.
+func (u *upstream) acquire(ctx context.Context, wait time.Duration) error {
+	if u.slots == nil {
+		return nil
+	}
+
+	select {
+	case u.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if wait <= 0 {
+		return ErrBulkheadFull
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case u.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return ErrBulkheadFull
+	}
+}
+
+func (u *upstream) release() {
+	if u.slots != nil {
+		<-u.slots
+	}
+}
+
+// upstream returns the breaker and bulkhead of the host, as in the request URL.
+func (c *Client) upstream(host string) *upstream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u, ok := c.upstreams[host]
+	if !ok {
+		u = &upstream{breaker: &breaker{options: c.options.Breaker}}
+		if c.options.MaxConcurrentPerHost > 0 {
+			u.slots = make(chan struct{}, c.options.MaxConcurrentPerHost)
+		}
+		c.upstreams[host] = u
+	}
+	return u
+}
+
+// BreakerState returns the state of the circuit breaker of the host, given as
+// in the request URLs, e.g. "auth:8080".
+func (c *Client) BreakerState(host string) BreakerState {
+	return c.upstream(host).breaker.currentState()
+}
@@ -0,0 +1,216 @@
+package http
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	netHttp "net/http"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hostOf returns the host of the server as used by BreakerState.
+func hostOf(t *testing.T, server *httptest.Server) string {
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return target.Host
+}
+
+func TestBreaker_StateTransitions(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		if failing.Load() {
+			w.WriteHeader(netHttp.StatusServiceUnavailable)
+		}
+	})
+	host := hostOf(t, server)
+	client := testClient(ClientOptions{
+		MaxRetries: -1,
+		Breaker:    BreakerOptions{FailureThreshold: 3, OpenTimeout: 50 * time.Millisecond},
+	})
+
+	for range 2 {
+		assert.Error(t, client.Get(context.Background(), server.URL, nil, nil))
+	}
+	assert.Equal(t, BreakerClosed, client.BreakerState(host), "The breaker should stay closed below the threshold")
+
+	// A success resets the consecutive failures
+	failing.Store(false)
+	require.NoError(t, client.Get(context.Background(), server.URL, nil, nil))
+	failing.Store(true)
+	for range 2 {
+		assert.Error(t, client.Get(context.Background(), server.URL, nil, nil))
+	}
+	assert.Equal(t, BreakerClosed, client.BreakerState(host))
+
+	assert.Error(t, client.Get(context.Background(), server.URL, nil, nil))
+	assert.Equal(t, BreakerOpen, client.BreakerState(host))
+
+	// Requests fail fast while the breaker is open
+	count.Store(0)
+	err := client.Get(context.Background(), server.URL, nil, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Zero(t, count.Load(), "No request should be sent while the breaker is open")
+
+	// A failed probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, BreakerHalfOpen, client.BreakerState(host))
+	assert.Error(t, client.Get(context.Background(), server.URL, nil, nil))
+	assert.EqualValues(t, 1, count.Load())
+	assert.Equal(t, BreakerOpen, client.BreakerState(host))
+
+	// A successful probe closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	failing.Store(false)
+	require.NoError(t, client.Get(context.Background(), server.URL, nil, nil))
+	assert.Equal(t, BreakerClosed, client.BreakerState(host))
+}
+
+func TestBreaker_HalfOpenProbes(t *testing.T) {
+	release := make(chan struct{})
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		if count == 1 {
+			w.WriteHeader(netHttp.StatusServiceUnavailable)
+			return
+		}
+		<-release
+	})
+	host := hostOf(t, server)
+	client := testClient(ClientOptions{
+		MaxRetries: -1,
+		Breaker:    BreakerOptions{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond},
+	})
+
+	assert.Error(t, client.Get(context.Background(), server.URL, nil, nil))
+	require.Equal(t, BreakerOpen, client.BreakerState(host))
+	time.Sleep(20 * time.Millisecond)
+
+	// Only HalfOpenRequests probes are let through
+	done := make(chan error)
+	go func() { done <- client.Get(context.Background(), server.URL, nil, nil) }()
+	require.Eventually(t, func() bool { return count.Load() == 2 }, time.Second, time.Millisecond)
+
+	err := client.Get(context.Background(), server.URL, nil, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen, "Requests over HalfOpenRequests should fail fast")
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, BreakerClosed, client.BreakerState(host))
+}
+
+func TestBreaker_DisabledByDefault(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		w.WriteHeader(netHttp.StatusServiceUnavailable)
+	})
+	client := testClient(ClientOptions{MaxRetries: -1})
+
+	for range 10 {
+		err := client.Get(context.Background(), server.URL, nil, nil)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.EqualValues(t, 10, count.Load())
+	assert.Equal(t, BreakerClosed, client.BreakerState(hostOf(t, server)))
+}
+
+func TestBreaker_IgnoresCanceledRequests(t *testing.T) {
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		<-r.Context().Done()
+	})
+	client := testClient(ClientOptions{
+		MaxRetries: -1,
+		Breaker:    BreakerOptions{FailureThreshold: 1},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	assert.ErrorIs(t, client.Get(ctx, server.URL, nil, nil), context.Canceled)
+	assert.Equal(t, BreakerClosed, client.BreakerState(hostOf(t, server)), "Requests canceled by the caller are not failures")
+}
+
+func TestBulkhead(t *testing.T) {
+	release := make(chan struct{})
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		<-release
+	})
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+
+	client := testClient(ClientOptions{MaxRetries: -1, MaxConcurrentPerHost: 2})
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, client.Get(context.Background(), server.URL, nil, nil))
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return len(client.upstream(hostOf(t, server)).slots) == 2
+	}, time.Second, time.Millisecond)
+
+	// Requests over the limit fail fast without MaxQueueWait
+	err := client.Get(context.Background(), server.URL, nil, nil)
+	assert.ErrorIs(t, err, ErrBulkheadFull)
+
+	// Other hosts are not limited by the slots of the host
+	other, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {})
+	assert.NoError(t, client.Get(context.Background(), other.URL, nil, nil))
+
+	close(release)
+	wg.Wait()
+	assert.NoError(t, client.Get(context.Background(), server.URL, nil, nil), "Slots should be released")
+}
+
+func TestBulkhead_QueueWait(t *testing.T) {
+	release := make(chan struct{})
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		if count == 1 {
+			<-release
+		}
+	})
+	host := hostOf(t, server)
+
+	client := testClient(ClientOptions{MaxRetries: -1, MaxConcurrentPerHost: 1, MaxQueueWait: time.Second})
+	done := make(chan error)
+	go func() { done <- client.Get(context.Background(), server.URL, nil, nil) }()
+	require.Eventually(t, func() bool { return len(client.upstream(host).slots) == 1 }, time.Second, time.Millisecond)
+
+	// Queued requests are canceled with their context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.Get(ctx, server.URL, nil, nil), context.DeadlineExceeded)
+
+	// Queued requests are sent once a slot is released
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	assert.NoError(t, client.Get(context.Background(), server.URL, nil, nil))
+	assert.NoError(t, <-done)
+
+	// Requests fail after MaxQueueWait
+	client = testClient(ClientOptions{MaxRetries: -1, MaxConcurrentPerHost: 1, MaxQueueWait: 20 * time.Millisecond})
+	block := make(chan struct{})
+	defer close(block)
+	slow, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	})
+	go client.Get(context.Background(), slow.URL, nil, nil)
+	require.Eventually(t, func() bool { return len(client.upstream(hostOf(t, slow)).slots) == 1 }, time.Second, time.Millisecond)
+	start := time.Now()
+	assert.ErrorIs(t, client.Get(context.Background(), slow.URL, nil, nil), ErrBulkheadFull)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
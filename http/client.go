@@ -0,0 +1,434 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	netHttp "net/http"
+	"net/url"
+)
+
+// ClientOptions configures a Client, zero values use the defaults.
+type ClientOptions struct {
+	// Timeout bounds each attempt, including reading the response, defaults to 10s.
+	// Streamed requests fail when no data is transferred within Timeout instead,
+	// so large transfers are not cut.
+	Timeout time.Duration
+	// HostTimeouts overrides Timeout per host, keyed by "host:port" or hostname.
+	HostTimeouts map[string]time.Duration
+	// MaxRetries is the number of retries of idempotent requests failing with a
+	// network error, a 5xx or a 429 response, defaults to 2. Negative disables retries.
+	MaxRetries int
+	// MinBackoff is the delay before the first retry, doubled on each retry, defaults to 100ms.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between retries, defaults to 5s.
+	MaxBackoff time.Duration
+	// MaxRetryAfter is the longest Retry-After delay honored, responses asking
+	// to wait longer are returned as is. Defaults to 30s.
+	MaxRetryAfter time.Duration
+	// Breaker configures the circuit breaker of each host, disabled unless
+	// Breaker.FailureThreshold is set.
+	Breaker BreakerOptions
+	// MaxConcurrentPerHost limits the requests in flight to each host, so a slow
+	// upstream doesn't hold every caller. Defaults to 0, unlimited.
+	MaxConcurrentPerHost int
+	// MaxQueueWait is how long a request waits for one of the MaxConcurrentPerHost
+	// slots before failing with ErrBulkheadFull. Defaults to 0, failing fast.
+	MaxQueueWait time.Duration
+	// Transport defaults to net/http.DefaultTransport.
+	Transport netHttp.RoundTripper
+}
+
+// Client sends JSON requests with a per-call context, retrying idempotent
+// requests with exponential backoff and jitter. Each host optionally has a
+// circuit breaker and a concurrency limit.
+type Client struct {
+	httpClient *netHttp.Client
+	options    ClientOptions
+
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+}
+
+// DefaultClient is used by the package-level functions.
+var DefaultClient = NewClient()
+
+// NewClient creates a Client.
+func NewClient(opts ...ClientOptions) *Client {
+	options := ClientOptions{}
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	if options.MaxRetries == 0 {
+		options.MaxRetries = 2
+	}
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = 100 * time.Millisecond
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = 5 * time.Second
+	}
+	if options.MaxRetryAfter <= 0 {
+		options.MaxRetryAfter = 30 * time.Second
+	}
+	if options.Breaker.OpenTimeout <= 0 {
+		options.Breaker.OpenTimeout = 30 * time.Second
+	}
+	if options.Breaker.HalfOpenRequests <= 0 {
+		options.Breaker.HalfOpenRequests = 1
+	}
+
+	return &Client{
+		// Timeouts are applied per attempt through the request context
+		httpClient: &netHttp.Client{Transport: options.Transport},
+		options:    options,
+		upstreams:  map[string]*upstream{},
+	}
+}
+
+func (c *Client) Get(ctx context.Context, url string, out any, headers map[string]string) error {
+	return c.Request(ctx, "GET", url, nil, out, headers)
+}
+
+func (c *Client) Post(ctx context.Context, url string, data any, out any, headers map[string]string) error {
+	return c.Request(ctx, "POST", url, data, out, headers)
+}
+
+func (c *Client) Put(ctx context.Context, url string, data any, out any, headers map[string]string) error {
+	return c.Request(ctx, "PUT", url, data, out, headers)
+}
+
+func (c *Client) Delete(ctx context.Context, url string, out any, headers map[string]string) error {
+	return c.Request(ctx, "DELETE", url, nil, out, headers)
+}
+
+// Request sends data JSON encoded and decodes the JSON response into out.
+// Responses with a non-2xx status are returned as *HTTPError.
+func (c *Client) Request(ctx context.Context, method string, url string, data any, out any, headers map[string]string) error {
+	_, err := c.sendJSON(ctx, method, url, data, out, headers)
+	return err
+}
+
+// sendJSON sends data JSON encoded and decodes the JSON response into out.
+func (c *Client) sendJSON(ctx context.Context, method string, url string, data any, out any, headers map[string]string) (*response, error) {
+	var body []byte
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		body = raw
+	}
+
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers["Content-Type"] = "application/json"
+
+	return c.send(ctx, method, url, body, out, headers)
+}
+
+// PostForm sends a URL-encoded POST request and decodes the JSON response into out.
+func (c *Client) PostForm(ctx context.Context, url string, data url.Values, out any, headers map[string]string) error {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers["Content-Type"] = "application/x-www-form-urlencoded"
+	_, err := c.send(ctx, "POST", url, []byte(data.Encode()), out, headers)
+	return err
+}
+
+// response is a response read within the timeout of its attempt.
+type response struct {
+	statusCode int
+	header     netHttp.Header
+	body       []byte
+}
+
+func (c *Client) send(ctx context.Context, method string, url string, body []byte, out any, headers map[string]string) (*response, error) {
+	resp, err := c.do(ctx, method, url, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeResponse(method, url, resp, out); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// decodeResponse decodes the JSON response into out, responses with a non-2xx
+// status are returned as *HTTPError.
+func decodeResponse(method string, url string, resp *response, out any) error {
+	if resp.statusCode < 200 || resp.statusCode > 299 {
+		return newHTTPError(method, url, resp)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(resp.body, out); err != nil {
+			return fmt.Errorf("failed to decode JSON response: %w (body: %s)", err, string(resp.body))
+		}
+	}
+
+	return nil
+}
+
+// do sends the request, retrying idempotent requests on network errors and
+// retryable responses. The last response or error is returned.
+func (c *Client) do(ctx context.Context, method string, url string, body []byte, headers map[string]string) (*response, error) {
+	retries := 0
+	if isIdempotent(method) && c.options.MaxRetries > 0 {
+		retries = c.options.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, method, url, body, headers)
+		if attempt >= retries || ctx.Err() != nil {
+			return resp, err
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if !isNetworkError(err) {
+				return nil, err
+			}
+			delay = c.backoff(attempt)
+		case isRetryableStatus(resp.statusCode):
+			retryAfter, ok := parseRetryAfter(resp.header.Get("Retry-After"))
+			if !ok {
+				delay = c.backoff(attempt)
+			} else if retryAfter > c.options.MaxRetryAfter {
+				return resp, nil
+			} else {
+				delay = retryAfter
+			}
+		default:
+			return resp, nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the request once and reads the response within the timeout of the host.
+func (c *Client) attempt(ctx context.Context, method string, url string, body []byte, headers map[string]string) (*response, error) {
+	var in io.Reader
+	if body != nil {
+		in = bytes.NewReader(body)
+	}
+	return c.exchange(ctx, method, url, in, headers, true, readResponse)
+}
+
+// exchange sends the request through the circuit breaker and the bulkhead of
+// the host, records its outcome and returns the response consumed by read.
+// Streamed requests are canceled when no data is transferred within the
+// timeout of the host, as a timeout of the whole exchange would cut large
+// transfers.
+func (c *Client) exchange(ctx context.Context, method string, rawURL string, body io.Reader, headers map[string]string, buffered bool, read func(*netHttp.Response) (*response, error)) (*response, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	upstream := c.upstream(target.Host)
+	probe, err := upstream.breaker.allow()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", target.Host, err)
+	}
+	if err := upstream.acquire(ctx, c.options.MaxQueueWait); err != nil {
+		upstream.breaker.cancel(probe)
+		return nil, fmt.Errorf("%s: %w", target.Host, err)
+	}
+	defer upstream.release()
+
+	timeout := c.timeout(target)
+	var reqCtx context.Context
+	var cancel context.CancelFunc
+	var idle *idleTimer
+	if buffered {
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		reqCtx, cancel = context.WithCancel(ctx)
+		idle = newIdleTimer(timeout, cancel)
+		defer idle.stop()
+		if closer, ok := body.(io.Closer); ok {
+			// The transport waits for the body to be read, unblock it once the request ends
+			stop := context.AfterFunc(reqCtx, func() { closer.Close() })
+			defer stop()
+		}
+		if body != nil {
+			body = &idleReader{reader: body, idle: idle}
+		}
+	}
+	defer cancel()
+
+	req, err := netHttp.NewRequestWithContext(reqCtx, method, rawURL, body)
+	if err != nil {
+		upstream.breaker.cancel(probe)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+
+	// Send request
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Canceled by the caller
+			upstream.breaker.cancel(probe)
+		} else {
+			upstream.breaker.record(probe, false)
+		}
+		return nil, idle.wrap(ctx, err)
+	}
+	defer resp.Body.Close()
+	upstream.breaker.record(probe, !isRetryableStatus(resp.StatusCode))
+
+	if idle != nil {
+		idle.touch()
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{&idleReader{reader: resp.Body, idle: idle}, resp.Body}
+	}
+
+	out, err := read(resp)
+	return out, idle.wrap(ctx, err)
+}
+
+// idleTimer cancels a streamed request when no data is transferred within timeout.
+type idleTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+	fired   atomic.Bool
+}
+
+func newIdleTimer(timeout time.Duration, cancel context.CancelFunc) *idleTimer {
+	idle := &idleTimer{timeout: timeout}
+	idle.timer = time.AfterFunc(timeout, func() {
+		idle.fired.Store(true)
+		cancel()
+	})
+	return idle
+}
+
+// touch restarts the timeout after some data was transferred.
+func (t *idleTimer) touch() {
+	if !t.fired.Load() {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func (t *idleTimer) stop() {
+	t.timer.Stop()
+}
+
+// wrap reports the errors of requests canceled by the timer as timeouts.
+func (t *idleTimer) wrap(ctx context.Context, err error) error {
+	if err == nil || t == nil || !t.fired.Load() || ctx.Err() != nil {
+		return err
+	}
+	return fmt.Errorf("no data transferred within %s: %w (%w)", t.timeout, context.DeadlineExceeded, err)
+}
+
+// idleReader restarts the idle timer on each read.
+type idleReader struct {
+	reader io.Reader
+	idle   *idleTimer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.idle.touch()
+	}
+	return n, err
+}
+
+// readResponse reads the whole response body.
+func readResponse(resp *netHttp.Response) (*response, error) {
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return &response{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       respBody,
+	}, nil
+}
+
+func (c *Client) timeout(u *url.URL) time.Duration {
+	if timeout, ok := c.options.HostTimeouts[u.Host]; ok {
+		return timeout
+	}
+	if timeout, ok := c.options.HostTimeouts[u.Hostname()]; ok {
+		return timeout
+	}
+	return c.options.Timeout
+}
+
+// backoff returns the delay before the retry following attempt, between half
+// and the full exponential delay.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.options.MaxBackoff
+	if attempt < 30 {
+		delay = min(c.options.MinBackoff<<attempt, c.options.MaxBackoff)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == netHttp.StatusTooManyRequests || statusCode >= 500
+}
+
+// isNetworkError reports whether sending the request failed, e.g. with a
+// refused connection or a timeout, as opposed to building it.
+func isNetworkError(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// parseRetryAfter parses the delay in seconds or the HTTP date of a Retry-After header.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := netHttp.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
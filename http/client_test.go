@@ -0,0 +1,241 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	netHttp "net/http"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingServer responds with respond, the number of received requests is
+// returned with the server.
+func countingServer(t *testing.T, respond func(w netHttp.ResponseWriter, r *netHttp.Request, count int)) (*httptest.Server, *atomic.Int32) {
+	count := &atomic.Int32{}
+	server := httptest.NewServer(netHttp.HandlerFunc(func(w netHttp.ResponseWriter, r *netHttp.Request) {
+		respond(w, r, int(count.Add(1)))
+	}))
+	t.Cleanup(server.Close)
+	return server, count
+}
+
+// testClient returns a Client with short backoffs.
+func testClient(opts ClientOptions) *Client {
+	opts.MinBackoff = time.Millisecond
+	opts.MaxBackoff = 5 * time.Millisecond
+	return NewClient(opts)
+}
+
+func TestClient_RetriesIdempotentRequests(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		if count < 3 {
+			w.WriteHeader(netHttp.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"name":"ok"}`))
+	})
+	client := testClient(ClientOptions{})
+
+	out := struct {
+		Name string `json:"name"`
+	}{}
+	require.NoError(t, client.Get(context.Background(), server.URL, &out, nil))
+	assert.Equal(t, "ok", out.Name)
+	assert.EqualValues(t, 3, count.Load(), "GET should be retried twice")
+}
+
+func TestClient_DoesNotRetryNonIdempotentRequests(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		w.WriteHeader(netHttp.StatusServiceUnavailable)
+	})
+	client := testClient(ClientOptions{})
+
+	err := client.Post(context.Background(), server.URL, map[string]string{"name": "order"}, nil, nil)
+	var httpErr *HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, netHttp.StatusServiceUnavailable, httpErr.StatusCode)
+	assert.True(t, httpErr.Temporary())
+	assert.EqualValues(t, 1, count.Load(), "POST should not be retried")
+
+	count.Store(0)
+	err = client.PostForm(context.Background(), server.URL, url.Values{"name": {"order"}}, nil, nil)
+	require.ErrorAs(t, err, &httpErr)
+	assert.EqualValues(t, 1, count.Load(), "Form POST should not be retried")
+}
+
+func TestClient_RetryableStatuses(t *testing.T) {
+	tests := []struct {
+		status   int
+		attempts int32
+	}{
+		{netHttp.StatusInternalServerError, 3},
+		{netHttp.StatusBadGateway, 3},
+		{netHttp.StatusTooManyRequests, 3},
+		{netHttp.StatusBadRequest, 1},
+		{netHttp.StatusNotFound, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"success":false,"error":{"code":` + strconv.Itoa(tt.status) + `,"message":"failed"}}`))
+			})
+			client := testClient(ClientOptions{})
+
+			err := client.Get(context.Background(), server.URL, nil, nil)
+			var httpErr *HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			assert.Equal(t, tt.status, httpErr.StatusCode)
+			require.NotNil(t, httpErr.ApiError)
+			assert.Equal(t, "failed", httpErr.ApiError.Message)
+			assert.Equal(t, tt.attempts, count.Load())
+		})
+	}
+}
+
+func TestClient_MaxRetries(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		w.WriteHeader(netHttp.StatusInternalServerError)
+	})
+
+	client := testClient(ClientOptions{MaxRetries: 4})
+	assert.Error(t, client.Get(context.Background(), server.URL, nil, nil))
+	assert.EqualValues(t, 5, count.Load())
+
+	count.Store(0)
+	client = testClient(ClientOptions{MaxRetries: -1})
+	assert.Error(t, client.Get(context.Background(), server.URL, nil, nil))
+	assert.EqualValues(t, 1, count.Load(), "Negative MaxRetries should disable retries")
+}
+
+type roundTripFunc func(*netHttp.Request) (*netHttp.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *netHttp.Request) (*netHttp.Response, error) {
+	return f(req)
+}
+
+func TestClient_RetriesNetworkErrors(t *testing.T) {
+	count := 0
+	client := testClient(ClientOptions{
+		Transport: roundTripFunc(func(*netHttp.Request) (*netHttp.Response, error) {
+			count++
+			return nil, errors.New("connection refused")
+		}),
+	})
+
+	err := client.Get(context.Background(), "http://upstream.test", nil, nil)
+	var urlErr *url.Error
+	assert.ErrorAs(t, err, &urlErr)
+	assert.Equal(t, 3, count, "Network errors of GET should be retried")
+
+	count = 0
+	assert.Error(t, client.Post(context.Background(), "http://upstream.test", nil, nil, nil))
+	assert.Equal(t, 1, count, "Network errors of POST should not be retried")
+}
+
+func TestClient_RetryAfter(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		if count == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(netHttp.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	client := testClient(ClientOptions{})
+
+	start := time.Now()
+	require.NoError(t, client.Get(context.Background(), server.URL, nil, nil))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "Retry-After should be honored over the backoff")
+	assert.EqualValues(t, 2, count.Load())
+}
+
+func TestClient_RetryAfterHTTPDate(t *testing.T) {
+	value, ok := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(netHttp.TimeFormat))
+	require.True(t, ok)
+	assert.InDelta(t, time.Hour.Seconds(), value.Seconds(), 2)
+
+	value, ok = parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(netHttp.TimeFormat))
+	require.True(t, ok)
+	assert.Zero(t, value, "Dates in the past should retry immediately")
+
+	_, ok = parseRetryAfter("soon")
+	assert.False(t, ok)
+	_, ok = parseRetryAfter("-1")
+	assert.False(t, ok)
+}
+
+func TestClient_MaxRetryAfter(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(netHttp.StatusServiceUnavailable)
+	})
+	client := testClient(ClientOptions{MaxRetryAfter: time.Second})
+
+	start := time.Now()
+	err := client.Get(context.Background(), server.URL, nil, nil)
+	var httpErr *HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, "60", httpErr.Header.Get("Retry-After"))
+	assert.EqualValues(t, 1, count.Load(), "Responses asking to wait over MaxRetryAfter should be returned as is")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_RetryCanceledByContext(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(netHttp.StatusServiceUnavailable)
+	})
+	client := testClient(ClientOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Get(ctx, server.URL, nil, nil)
+	var httpErr *HTTPError
+	require.ErrorAs(t, err, &httpErr, "The last response should be returned when the context ends")
+	assert.EqualValues(t, 1, count.Load())
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_HostTimeouts(t *testing.T) {
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte(`{}`))
+	})
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	client := testClient(ClientOptions{MaxRetries: -1})
+	require.NoError(t, client.Get(context.Background(), server.URL, nil, nil), "The default timeout should not apply")
+
+	for _, host := range []string{target.Host, target.Hostname()} {
+		t.Run(host, func(t *testing.T) {
+			client := testClient(ClientOptions{
+				MaxRetries:   -1,
+				HostTimeouts: map[string]time.Duration{host: 50 * time.Millisecond},
+			})
+
+			start := time.Now()
+			err := client.Get(context.Background(), server.URL, nil, nil)
+			assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+			assert.Less(t, time.Since(start), 200*time.Millisecond)
+		})
+	}
+
+	client = testClient(ClientOptions{MaxRetries: -1, Timeout: 50 * time.Millisecond})
+	err = client.Get(context.Background(), server.URL, nil, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
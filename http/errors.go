@@ -0,0 +1,56 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+
+	netHttp "net/http"
+
+	"github.com/tphan267/common/api"
+)
+
+// maxErrorBodySize bounds the response body kept in an HTTPError.
+const maxErrorBodySize = 4096
+
+// HTTPError is returned for responses with a non-2xx status. Use errors.As to
+// inspect it, e.g. to tell a 401 from an outage.
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     netHttp.Header
+	// Body is the response body, truncated to 4KB.
+	Body []byte
+	// ApiError is the error of the response when its body is an api.ApiResponse.
+	ApiError *api.ApiError
+}
+
+func newHTTPError(method string, url string, resp *response) *HTTPError {
+	httpErr := &HTTPError{
+		Method:     method,
+		URL:        url,
+		StatusCode: resp.statusCode,
+		Header:     resp.header,
+		Body:       resp.body[:min(len(resp.body), maxErrorBodySize)],
+	}
+
+	envelope := api.ApiResponse{}
+	if err := json.Unmarshal(resp.body, &envelope); err == nil && !envelope.Success && envelope.Error != nil {
+		httpErr.ApiError = envelope.Error
+	}
+
+	return httpErr
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, netHttp.StatusText(e.StatusCode))
+	if e.ApiError != nil && e.ApiError.Message != "" {
+		return msg + ": " + e.ApiError.Message
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed later, i.e. for 5xx and 429 responses.
+func (e *HTTPError) Temporary() bool {
+	return isRetryableStatus(e.StatusCode)
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	netHttp "net/http"
+)
+
+// FilePart is a file of a multipart/form-data request, streamed from Reader.
+type FilePart struct {
+	FieldName string
+	FileName  string
+	// ContentType defaults to application/octet-stream.
+	ContentType string
+	Reader      io.Reader
+}
+
+// ProgressFunc is called while a response body is streamed, with the bytes
+// written so far and the Content-Length of the response, -1 when unknown.
+type ProgressFunc func(written int64, total int64)
+
+// PostMultipart sends fields and files as multipart/form-data and decodes the
+// JSON response into out. Files are streamed from their Reader instead of
+// being held in memory, so the request is not retried. The request fails when
+// no data is sent or received within the timeout of the host.
+func (c *Client) PostMultipart(ctx context.Context, url string, fields map[string]string, files []FilePart, out any, headers map[string]string) error {
+	body, writer := io.Pipe()
+	// Stops the writing goroutine when the request ends before the whole body is sent
+	defer body.Close()
+
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeMultipart(form, fields, files))
+	}()
+
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers["Content-Type"] = form.FormDataContentType()
+
+	resp, err := c.exchange(ctx, "POST", url, body, headers, false, readResponse)
+	if err != nil {
+		return err
+	}
+	return decodeResponse("POST", url, resp, out)
+}
+
+func writeMultipart(form *multipart.Writer, fields map[string]string, files []FilePart) error {
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		if err := form.WriteField(name, fields[name]); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(file.FieldName), escapeQuotes(file.FileName)))
+		header.Set("Content-Type", contentType)
+
+		part, err := form.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, file.Reader); err != nil {
+			return fmt.Errorf("failed to read file %q: %w", file.FileName, err)
+		}
+	}
+
+	return form.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// Download streams the body of a GET response to w without buffering it and
+// returns the number of bytes written. progress may be nil. Responses with a
+// non-2xx status are returned as *HTTPError and nothing is written. The
+// request fails when no data is received within the timeout of the host, and
+// is not retried, as w may have been partly written.
+func (c *Client) Download(ctx context.Context, url string, w io.Writer, headers map[string]string, progress ProgressFunc) (int64, error) {
+	var written int64
+	_, err := c.exchange(ctx, "GET", url, nil, headers, false, func(resp *netHttp.Response) (*response, error) {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+			return nil, newHTTPError("GET", url, &response{
+				statusCode: resp.StatusCode,
+				header:     resp.Header,
+				body:       body,
+			})
+		}
+
+		if progress != nil {
+			w = &progressWriter{writer: w, total: resp.ContentLength, progress: progress}
+		}
+
+		var err error
+		written, err = io.Copy(w, resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stream response body: %w", err)
+		}
+		return &response{statusCode: resp.StatusCode, header: resp.Header}, nil
+	})
+
+	return written, err
+}
+
+// DownloadFile downloads to the file at path, see Download. The body is
+// written to a temporary file in the same directory, renamed to path once
+// complete, so a failed download leaves no partial file.
+func (c *Client) DownloadFile(ctx context.Context, url string, path string, headers map[string]string, progress ProgressFunc) (int64, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	// Temporary files are only readable by their owner
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		return 0, err
+	}
+
+	written, err := c.Download(ctx, url, file, headers, progress)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+
+	return written, os.Rename(file.Name(), path)
+}
+
+// progressWriter reports the bytes written to writer.
+type progressWriter struct {
+	writer   io.Writer
+	written  int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	w.progress(w.written, w.total)
+	return n, err
+}
@@ -0,0 +1,254 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	netHttp "net/http"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostMultipart(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		if !assert.NoError(t, r.ParseMultipartForm(1<<20)) {
+			w.WriteHeader(netHttp.StatusBadRequest)
+			return
+		}
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		assert.Equal(t, "Invoice", r.FormValue("title"))
+		assert.Equal(t, "2", r.FormValue("pages"))
+
+		files := r.MultipartForm.File["document"]
+		require.Len(t, files, 2)
+		assert.Equal(t, `in"voice.pdf`, files[0].Filename)
+		assert.Equal(t, "application/pdf", files[0].Header.Get("Content-Type"))
+		assert.Equal(t, "notes.txt", files[1].Filename)
+		assert.Equal(t, "application/octet-stream", files[1].Header.Get("Content-Type"))
+
+		file, err := files[0].Open()
+		require.NoError(t, err)
+		defer file.Close()
+		content, _ := io.ReadAll(file)
+
+		w.Write([]byte(`{"size":` + strconv.Itoa(len(content)) + `}`))
+	})
+	client := testClient(ClientOptions{})
+
+	out := struct {
+		Size int `json:"size"`
+	}{}
+	err := client.PostMultipart(context.Background(), server.URL,
+		map[string]string{"title": "Invoice", "pages": "2"},
+		[]FilePart{
+			{FieldName: "document", FileName: `in"voice.pdf`, ContentType: "application/pdf", Reader: strings.NewReader(strings.Repeat("x", 100_000))},
+			{FieldName: "document", FileName: "notes.txt", Reader: strings.NewReader("notes")},
+		},
+		&out, map[string]string{"X-Api-Key": "secret"})
+	require.NoError(t, err)
+	assert.Equal(t, 100_000, out.Size)
+	assert.EqualValues(t, 1, count.Load())
+}
+
+func TestPostMultipart_Errors(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(netHttp.StatusServiceUnavailable)
+	})
+	client := testClient(ClientOptions{})
+
+	files := []FilePart{{FieldName: "file", FileName: "a.txt", Reader: strings.NewReader("content")}}
+	err := client.PostMultipart(context.Background(), server.URL, nil, files, nil, nil)
+	var httpErr *HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, netHttp.StatusServiceUnavailable, httpErr.StatusCode)
+	assert.EqualValues(t, 1, count.Load(), "Streamed requests should not be retried")
+
+	// Errors reading the files abort the request
+	files = []FilePart{{FieldName: "file", FileName: "a.txt", Reader: io.MultiReader(strings.NewReader("partial"), errReader{})}}
+	err = client.PostMultipart(context.Background(), server.URL, nil, files, nil, nil)
+	assert.ErrorIs(t, err, errRead)
+}
+
+var errRead = errors.New("read failed")
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errRead
+}
+
+func TestDownload_Progress(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100_000)
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		assert.Equal(t, "bytes=0-", r.Header.Get("Range"))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	})
+	client := testClient(ClientOptions{})
+
+	var mu sync.Mutex
+	var calls []int64
+	buf := &bytes.Buffer{}
+	written, err := client.Download(context.Background(), server.URL, buf, map[string]string{"Range": "bytes=0-"}, func(written int64, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.EqualValues(t, len(content), total)
+		calls = append(calls, written)
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), written)
+	assert.Equal(t, content, buf.Bytes())
+
+	require.NotEmpty(t, calls)
+	assert.IsIncreasing(t, calls)
+	assert.EqualValues(t, len(content), calls[len(calls)-1])
+}
+
+func TestDownload_UnknownLength(t *testing.T) {
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		w.Write([]byte("chunk"))
+		w.(netHttp.Flusher).Flush()
+		w.Write([]byte("ed"))
+	})
+	client := testClient(ClientOptions{})
+
+	var total int64
+	buf := &bytes.Buffer{}
+	_, err := client.Download(context.Background(), server.URL, buf, nil, func(_ int64, t int64) { total = t })
+	require.NoError(t, err)
+	assert.Equal(t, "chunked", buf.String())
+	assert.EqualValues(t, -1, total)
+}
+
+func TestDownload_Non2xx(t *testing.T) {
+	server, count := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		w.WriteHeader(netHttp.StatusNotFound)
+		w.Write([]byte(`{"success":false,"error":{"code":404,"message":"File not found"}}`))
+	})
+	client := testClient(ClientOptions{})
+
+	buf := &bytes.Buffer{}
+	written, err := client.Download(context.Background(), server.URL, buf, nil, nil)
+	var httpErr *HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, netHttp.StatusNotFound, httpErr.StatusCode)
+	require.NotNil(t, httpErr.ApiError)
+	assert.Equal(t, "File not found", httpErr.ApiError.Message)
+	assert.Zero(t, written)
+	assert.Zero(t, buf.Len(), "Error bodies should not be written")
+	assert.EqualValues(t, 1, count.Load())
+}
+
+func TestDownloadFile(t *testing.T) {
+	status := netHttp.StatusOK
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		w.WriteHeader(status)
+		w.Write([]byte("file content"))
+	})
+	client := testClient(ClientOptions{})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.csv")
+
+	written, err := client.DownloadFile(context.Background(), server.URL, path, nil, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, len("file content"), written)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "file content", string(content))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	// Failed downloads leave the existing file and no temporary file
+	status = netHttp.StatusInternalServerError
+	require.NoError(t, os.WriteFile(path, []byte("previous"), 0o644))
+	_, err = client.DownloadFile(context.Background(), server.URL, path, nil, nil)
+	assert.Error(t, err)
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "previous", string(content))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "Temporary files should be removed")
+	assert.Equal(t, "report.csv", entries[0].Name())
+
+	// Downloads to missing directories fail before sending the request
+	_, err = client.DownloadFile(context.Background(), server.URL, filepath.Join(dir, "missing", "report.csv"), nil, nil)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDownload_IdleTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		switch r.URL.Path {
+		case "/stalled":
+			w.Write([]byte("partial"))
+			w.(netHttp.Flusher).Flush()
+		case "/slow":
+			// Slower than the timeout in total, but never idle for long
+			for range 6 {
+				w.Write([]byte("chunk"))
+				w.(netHttp.Flusher).Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+			return
+		}
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	})
+	client := testClient(ClientOptions{Timeout: 60 * time.Millisecond})
+
+	for _, path := range []string{"/headers", "/stalled"} {
+		t.Run(path, func(t *testing.T) {
+			start := time.Now()
+			_, err := client.Download(context.Background(), server.URL+path, io.Discard, nil, nil)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Less(t, time.Since(start), time.Second)
+		})
+	}
+
+	buf := &bytes.Buffer{}
+	_, err := client.Download(context.Background(), server.URL+"/slow", buf, nil, nil)
+	require.NoError(t, err, "Transfers making progress should not time out")
+	assert.Equal(t, strings.Repeat("chunk", 6), buf.String())
+
+	// The caller's context is reported as is
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = client.Download(ctx, server.URL+"/stalled", io.Discard, nil, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPostMultipart_IdleTimeout(t *testing.T) {
+	server, _ := countingServer(t, func(w netHttp.ResponseWriter, r *netHttp.Request, count int) {
+		io.Copy(io.Discard, r.Body)
+	})
+	client := testClient(ClientOptions{Timeout: 60 * time.Millisecond})
+
+	// A file whose reader stalls after the first bytes
+	stalled, stall := io.Pipe()
+	defer stall.Close()
+	go stall.Write([]byte("partial"))
+
+	start := time.Now()
+	files := []FilePart{{FieldName: "file", FileName: "a.txt", Reader: stalled}}
+	err := client.PostMultipart(context.Background(), server.URL, nil, files, nil, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,263 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Claims holds the registered claims (RFC 7519) carried in the protected
+// headers of issued tokens, next to the iat and exp headers. Signed tokens with
+// a JSON object payload also carry them in the payload, for third-party
+// verifiers reading the claims from the payload.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	NotBefore time.Time
+	ID        string
+	// Type is the "typ" header, used to tell token kinds (e.g. refresh tokens) apart.
+	Type string
+
+	// IssuedAt and ExpiresAt are stamped by the KeyManager and only set on parsed claims.
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// ValidationPolicy configures how a KeyManager validates the claims of a token.
+type ValidationPolicy struct {
+	// Issuer, when set, must match the iss claim.
+	Issuer string
+	// Audiences, when set, must contain at least one of the aud claim values.
+	Audiences []string
+	// Leeway is the clock skew tolerated when checking exp and nbf.
+	Leeway time.Duration
+	// RequiredClaims lists claim names that must be present, e.g. "exp", "sub", "jti".
+	RequiredClaims []string
+}
+
+type headerSetter interface {
+	Set(string, any) error
+}
+
+type headerGetter interface {
+	Get(string, any) error
+}
+
+// generateTokenID returns a random identifier for the jti claim.
+func generateTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// setClaimHeaders stamps iat, jti, exp, the registered claims and the custom
+// headers, and returns the registered claims it stamped. Every token gets a
+// unique jti unless one is given in the claims.
+func setClaimHeaders(headers headerSetter, opts *JWEOptions) (map[string]any, error) {
+	registered := map[string]any{"iat": time.Now().Unix()}
+
+	if opts == nil || opts.Claims == nil || opts.Claims.ID == "" {
+		jti, err := generateTokenID()
+		if err != nil {
+			return nil, err
+		}
+		registered["jti"] = jti
+	}
+
+	if opts != nil {
+		if opts.ExpiresIn > 0 {
+			registered["exp"] = time.Now().Add(opts.ExpiresIn).Unix()
+		}
+
+		if claims := opts.Claims; claims != nil {
+			if claims.Issuer != "" {
+				registered["iss"] = claims.Issuer
+			}
+			if claims.Subject != "" {
+				registered["sub"] = claims.Subject
+			}
+			if len(claims.Audience) > 0 {
+				registered["aud"] = claims.Audience
+			}
+			if !claims.NotBefore.IsZero() {
+				registered["nbf"] = claims.NotBefore.Unix()
+			}
+			if claims.ID != "" {
+				registered["jti"] = claims.ID
+			}
+		}
+	}
+
+	for name, value := range registered {
+		headers.Set(name, value)
+	}
+
+	if opts == nil {
+		return registered, nil
+	}
+
+	if opts.Claims != nil && opts.Claims.Type != "" {
+		headers.Set("typ", opts.Claims.Type)
+	}
+
+	for k, v := range opts.Headers {
+		headers.Set(k, v)
+	}
+
+	return registered, nil
+}
+
+// addPayloadClaims adds the registered claims to a JSON object payload, where
+// standard JWT libraries read them. Other payloads are returned as is, their
+// claims are only carried in the protected headers.
+func addPayloadClaims(payload []byte, registered map[string]any) ([]byte, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &object); err != nil || object == nil {
+		return payload, nil
+	}
+
+	for name, value := range registered {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode claim %s: %v", name, err)
+		}
+		object[name] = raw
+	}
+
+	return json.Marshal(object)
+}
+
+// payloadClaims reads the claims of a JSON object payload.
+type payloadClaims map[string]any
+
+func newPayloadClaims(payload []byte) payloadClaims {
+	var claims payloadClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+func (c payloadClaims) Get(name string, dst any) error {
+	value, ok := c[name]
+	// typ is a header parameter, payloads can't change the kind of a token
+	if !ok || name == "typ" {
+		return fmt.Errorf("claim %s not found", name)
+	}
+	out, ok := dst.(*any)
+	if !ok {
+		return fmt.Errorf("unsupported destination %T", dst)
+	}
+	*out = value
+	return nil
+}
+
+// claimSources reads each claim from the first source that has it.
+type claimSources []headerGetter
+
+func (s claimSources) Get(name string, dst any) error {
+	err := fmt.Errorf("claim %s not found", name)
+	for _, source := range s {
+		if err = source.Get(name, dst); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// claimsFromHeaders reads the registered claims back from the protected headers.
+// It returns the parsed claims and the names of the claims that are present.
+func claimsFromHeaders(headers headerGetter) (*Claims, []string) {
+	claims := &Claims{}
+	var present []string
+
+	getString := func(name string, dst *string) {
+		var value any
+		if err := headers.Get(name, &value); err == nil {
+			if str, ok := value.(string); ok {
+				*dst = str
+				present = append(present, name)
+			}
+		}
+	}
+	getTime := func(name string, dst *time.Time) {
+		var value any
+		if err := headers.Get(name, &value); err == nil {
+			if num, ok := value.(float64); ok {
+				*dst = time.Unix(int64(num), 0)
+				present = append(present, name)
+			}
+		}
+	}
+
+	getString("iss", &claims.Issuer)
+	getString("sub", &claims.Subject)
+	getString("jti", &claims.ID)
+	getString("typ", &claims.Type)
+	getTime("nbf", &claims.NotBefore)
+	getTime("iat", &claims.IssuedAt)
+	getTime("exp", &claims.ExpiresAt)
+
+	var aud any
+	if err := headers.Get("aud", &aud); err == nil {
+		switch v := aud.(type) {
+		case string:
+			claims.Audience = []string{v}
+		case []any:
+			for _, item := range v {
+				if str, ok := item.(string); ok {
+					claims.Audience = append(claims.Audience, str)
+				}
+			}
+		}
+		if len(claims.Audience) > 0 {
+			present = append(present, "aud")
+		}
+	}
+
+	return claims, present
+}
+
+// Validate checks the claims against the policy.
+func (p ValidationPolicy) Validate(claims *Claims, present []string) error {
+	now := time.Now()
+
+	if !claims.ExpiresAt.IsZero() && now.After(claims.ExpiresAt.Add(p.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	if !claims.NotBefore.IsZero() && now.Add(p.Leeway).Before(claims.NotBefore) {
+		return ErrTokenNotYetValid
+	}
+
+	if p.Issuer != "" && claims.Issuer != p.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidClaims, claims.Issuer)
+	}
+
+	if len(p.Audiences) > 0 && !slices.ContainsFunc(claims.Audience, func(aud string) bool {
+		return slices.Contains(p.Audiences, aud)
+	}) {
+		return fmt.Errorf("%w: audience %v is not allowed", ErrInvalidClaims, claims.Audience)
+	}
+
+	for _, name := range p.RequiredClaims {
+		if !slices.Contains(present, name) {
+			return fmt.Errorf("%w: missing required claim %s", ErrInvalidClaims, name)
+		}
+	}
+
+	return nil
+}
+
+// SetValidationPolicy sets the policy used to validate the claims of tokens.
+func (km *KeyManager) SetValidationPolicy(policy ValidationPolicy) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.policy = policy
+}
@@ -0,0 +1,22 @@
+package jwt
+
+import "errors"
+
+// Errors returned when decrypting or verifying a token, use errors.Is to tell
+// them apart. The returned errors wrap them with details.
+var (
+	// ErrMalformed is returned for tokens that can't be parsed or lack required headers.
+	ErrMalformed = errors.New("malformed token")
+	// ErrUnknownKey is returned when the kid of the token matches no known key,
+	// e.g. because keys have not been refreshed since a rotation.
+	ErrUnknownKey = errors.New("unknown token key")
+	// ErrTampered is returned when decryption or signature verification fails
+	// with the key identified by the kid.
+	ErrTampered = errors.New("token integrity check failed")
+	// ErrTokenExpired is returned for authentic tokens past their exp claim.
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrTokenNotYetValid is returned for authentic tokens before their nbf claim.
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	// ErrInvalidClaims is returned when the claims don't satisfy the ValidationPolicy.
+	ErrInvalidClaims = errors.New("invalid token claims")
+)
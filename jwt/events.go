@@ -0,0 +1,110 @@
+package jwt
+
+import "time"
+
+// KeyEventType identifies the kind of a KeyEvent.
+type KeyEventType string
+
+const (
+	// KeyRotated is emitted when the KeyManager generated a new current key.
+	KeyRotated KeyEventType = "rotated"
+	// KeysRefreshed is emitted when reloading the keys from the store changed
+	// the current key or the history, e.g. after another replica rotated.
+	KeysRefreshed KeyEventType = "refreshed"
+	// OldKeyUsed is emitted when a token is decrypted or verified with a retired key.
+	OldKeyUsed KeyEventType = "old_key_used"
+	// RotationFailed is emitted when rotating the current key failed.
+	RotationFailed KeyEventType = "rotation_failed"
+	// RefreshFailed is emitted when reloading the keys from the store failed.
+	RefreshFailed KeyEventType = "refresh_failed"
+)
+
+// KeyEvent describes a change or a notable use of the keys of a KeyManager.
+type KeyEvent struct {
+	Type KeyEventType
+	KID  string // Key concerned by the event, empty for failures
+	Err  error  // Cause of failure events
+	Time time.Time
+}
+
+// KeyManagerStatus is a snapshot of the state of a KeyManager, e.g. for health endpoints.
+type KeyManagerStatus struct {
+	KID               string    `json:"kid"`
+	Expiry            time.Time `json:"expiry"`
+	Alg               string    `json:"alg,omitempty"`
+	HistorySize       int       `json:"historySize"`
+	ValidationOnly    bool      `json:"validationOnly"`
+	LastRefresh       time.Time `json:"lastRefresh"`
+	LastRefreshError  string    `json:"lastRefreshError,omitempty"`
+	LastRotation      time.Time `json:"lastRotation"`
+	LastRotationError string    `json:"lastRotationError,omitempty"`
+	// RotationFailures counts the consecutive failed rotation checks.
+	RotationFailures int `json:"rotationFailures"`
+}
+
+type keyEventSubscriber struct {
+	id int
+	fn func(KeyEvent)
+}
+
+// Subscribe registers fn to receive the key events of the KeyManager and returns
+// a function cancelling the subscription. fn is called synchronously from the
+// goroutine that triggered the event, so it must return quickly.
+func (km *KeyManager) Subscribe(fn func(KeyEvent)) func() {
+	km.subscribersMu.Lock()
+	defer km.subscribersMu.Unlock()
+
+	km.nextSubscriber++
+	id := km.nextSubscriber
+	km.subscribers = append(km.subscribers, keyEventSubscriber{id: id, fn: fn})
+
+	return func() {
+		km.subscribersMu.Lock()
+		defer km.subscribersMu.Unlock()
+		for i, subscriber := range km.subscribers {
+			if subscriber.id == id {
+				km.subscribers = append(km.subscribers[:i:i], km.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// emit delivers the event to the subscribers. It must not be called with km.mu held.
+func (km *KeyManager) emit(event KeyEvent) {
+	event.Time = time.Now()
+
+	km.subscribersMu.RLock()
+	subscribers := make([]keyEventSubscriber, len(km.subscribers))
+	copy(subscribers, km.subscribers)
+	km.subscribersMu.RUnlock()
+
+	for _, subscriber := range subscribers {
+		subscriber.fn(event)
+	}
+}
+
+// Status returns a snapshot of the current key and of the last refresh and rotation.
+func (km *KeyManager) Status() KeyManagerStatus {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	status := KeyManagerStatus{
+		KID:              km.currentKey.KID(),
+		Expiry:           km.currentKey.Expiry,
+		Alg:              km.currentKey.Alg,
+		HistorySize:      len(km.keyHistory),
+		ValidationOnly:   km.validationOnly,
+		LastRefresh:      km.lastRefresh,
+		LastRotation:     km.lastRotation,
+		RotationFailures: km.rotationFailures,
+	}
+	if km.lastRefreshErr != nil {
+		status.LastRefreshError = km.lastRefreshErr.Error()
+	}
+	if km.lastRotationErr != nil {
+		status.LastRotationError = km.lastRotationErr.Error()
+	}
+
+	return status
+}
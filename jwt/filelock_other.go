@@ -0,0 +1,16 @@
+//go:build !unix
+
+package jwt
+
+import "os"
+
+// Advisory file locks are not available, FileKeyStore only serializes access
+// within the process on these platforms.
+
+func lockFileHandle(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFileHandle(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,241 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fileKeyStoreData is the JSON document persisted by FileKeyStore.
+type fileKeyStoreData struct {
+	NextID uint       `json:"nextId"`
+	Keys   []KeyEntry `json:"keys"`
+	Lock   *KeyLock   `json:"lock,omitempty"`
+}
+
+// FileKeyStore persists key entries in a JSON file readable by the owner only.
+// Writes replace the file atomically and are serialized with an advisory lock
+// on a sibling ".lock" file, so several processes can share the store.
+type FileKeyStore struct {
+	path string
+	keks []*KeyEncryptionKey
+	mu   sync.Mutex
+}
+
+// NewFileKeyStore initializes a new FileKeyStore. The file is created on the
+// first SaveKey, an existing file must not be accessible by group or others.
+// Keys are wrapped with the key-encryption-keys like in NewGormKeyStore, so a
+// copy of the file does not leak them.
+func NewFileKeyStore(path string, keks ...*KeyEncryptionKey) (*FileKeyStore, error) {
+	info, err := os.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to stat key file: %v", err)
+	}
+	if err == nil && info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("key file %s has insecure permissions %v, expected 0600", path, info.Mode().Perm())
+	}
+
+	return &FileKeyStore{path: path, keks: keks}, nil
+}
+
+// SaveKey appends a key entry to the file, wrapped with the current KEK if any.
+func (s *FileKeyStore) SaveKey(entry KeyEntry) error {
+	if err := wrapKey(s.keks, &entry); err != nil {
+		return err
+	}
+	return s.update(func(data *fileKeyStoreData) bool {
+		data.NextID++
+		entry.ID = data.NextID
+		data.Keys = append(data.Keys, entry)
+		return true
+	})
+}
+
+// GetAllKeys retrieves all saved key entries, sorted by expiry ascending, and unwraps their keys.
+func (s *FileKeyStore) GetAllKeys() ([]KeyEntry, error) {
+	var data *fileKeyStoreData
+	err := s.withLock(false, func() error {
+		var err error
+		data, err = s.read()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range data.Keys {
+		if err := unwrapKey(s.keks, &data.Keys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(data.Keys, func(i, j int) bool {
+		return data.Keys[i].Expiry.Before(data.Keys[j].Expiry)
+	})
+	return data.Keys, nil
+}
+
+// RewrapKeys wraps all stored keys with the current KEK, see GormKeyStore.RewrapKeys.
+func (s *FileKeyStore) RewrapKeys() (int, error) {
+	if len(s.keks) == 0 {
+		return 0, fmt.Errorf("no key-encryption-key configured")
+	}
+
+	count := 0
+	var rewrapErr error
+	err := s.update(func(data *fileKeyStoreData) bool {
+		for i, entry := range data.Keys {
+			if !needsRewrap(s.keks, entry) {
+				continue
+			}
+			if rewrapErr = unwrapKey(s.keks, &entry); rewrapErr != nil {
+				return false
+			}
+			if rewrapErr = wrapKey(s.keks, &entry); rewrapErr != nil {
+				return false
+			}
+			data.Keys[i] = entry
+			count++
+		}
+		return count > 0
+	})
+	if err == nil {
+		err = rewrapErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to rewrap keys: %v", err)
+	}
+
+	return count, nil
+}
+
+// PruneKeys deletes the key entries that expire before the given time.
+func (s *FileKeyStore) PruneKeys(before time.Time) error {
+	return s.update(func(data *fileKeyStoreData) bool {
+		count := len(data.Keys)
+		data.Keys = slices.DeleteFunc(data.Keys, func(entry KeyEntry) bool {
+			return entry.Expiry.Before(before)
+		})
+		return len(data.Keys) != count
+	})
+}
+
+// AcquireRotationLock takes the rotation lock recorded in the file, see KeyLocker.
+func (s *FileKeyStore) AcquireRotationLock(owner string, ttl time.Duration) (bool, error) {
+	acquired := false
+	err := s.update(func(data *fileKeyStoreData) bool {
+		now := time.Now()
+		if data.Lock != nil && data.Lock.Owner != owner && now.Before(data.Lock.ExpiresAt) {
+			return false
+		}
+		data.Lock = &KeyLock{Name: rotationLockName, Owner: owner, ExpiresAt: now.Add(ttl)}
+		acquired = true
+		return true
+	})
+	return acquired, err
+}
+
+// ReleaseRotationLock releases the rotation lock recorded in the file, see KeyLocker.
+func (s *FileKeyStore) ReleaseRotationLock(owner string) error {
+	return s.update(func(data *fileKeyStoreData) bool {
+		if data.Lock == nil || data.Lock.Owner != owner {
+			return false
+		}
+		data.Lock = nil
+		return true
+	})
+}
+
+// update applies fn to the stored data under an exclusive lock and writes the
+// result back when fn reports a change.
+func (s *FileKeyStore) update(fn func(data *fileKeyStoreData) bool) error {
+	return s.withLock(true, func() error {
+		data, err := s.read()
+		if err != nil {
+			return err
+		}
+		if !fn(data) {
+			return nil
+		}
+		return s.write(data)
+	})
+}
+
+// withLock runs fn holding the in-process mutex and the advisory file lock.
+func (s *FileKeyStore) withLock(exclusive bool, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lockFile, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file: %v", err)
+	}
+	defer lockFile.Close()
+
+	if err := lockFileHandle(lockFile, exclusive); err != nil {
+		return fmt.Errorf("failed to lock key file: %v", err)
+	}
+	defer unlockFileHandle(lockFile)
+
+	return fn()
+}
+
+func (s *FileKeyStore) read() (*fileKeyStoreData, error) {
+	data := &fileKeyStoreData{}
+
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	if err := json.Unmarshal(content, data); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %v", err)
+	}
+	return data, nil
+}
+
+// write replaces the file atomically: the data is written and synced to a
+// temporary file in the same directory, which is then renamed over the file.
+func (s *FileKeyStore) write(data *fileKeyStoreData) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode keys: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary key file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set key file permissions: %v", err)
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write key file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync key file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close key file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace key file: %v", err)
+	}
+	return nil
+}
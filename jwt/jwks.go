@@ -0,0 +1,263 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// jwksMaxAge is the Cache-Control max-age advertised by JWKSAPIHandler.
+const jwksMaxAge = 5 * time.Minute
+
+// PublicKeySet returns the public half of the current signing keys as a JWK Set.
+// Symmetric JWE keys are never included.
+func (km *KeyManager) PublicKeySet() (jwk.Set, error) {
+	keys, err := km.GetCurrentKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	set := jwk.NewSet()
+	for _, entry := range keys {
+		if entry.PublicKey == nil {
+			continue
+		}
+
+		publicKey, err := x509.ParsePKIXPublicKey(entry.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %v", err)
+		}
+
+		key, err := jwk.Import(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import public key: %v", err)
+		}
+		key.Set(jwk.KeyIDKey, entry.KID())
+		key.Set(jwk.AlgorithmKey, entry.Alg)
+		key.Set(jwk.KeyUsageKey, "sig")
+		key.Set("exp", entry.Expiry.Unix())
+
+		if err := set.AddKey(key); err != nil {
+			return nil, fmt.Errorf("failed to add key to set: %v", err)
+		}
+	}
+
+	return set, nil
+}
+
+// JWKSAPIHandler publishes the public signing keys as an RFC 7517 JWK Set.
+func (km *KeyManager) JWKSAPIHandler(c *fiber.Ctx) error {
+	set, err := km.PublicKeySet()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to build key set",
+		})
+	}
+
+	// c.JSON would overwrite the JWK Set media type
+	body, err := json.Marshal(set)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to encode key set",
+		})
+	}
+
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	c.Set(fiber.HeaderContentType, "application/jwk-set+json")
+	return c.Send(body)
+}
+
+// JWKSStore is a read-only KeyStore backed by a JWKS URL.
+type JWKSStore struct {
+	jwksURL string
+	client  *http.Client
+
+	cache       []KeyEntry
+	cacheMu     sync.RWMutex
+	cacheTTL    time.Duration
+	cacheExpiry time.Time
+}
+
+// NewJWKSStore initializes a new JWKSStore.
+// - jwksURL: The JWKS endpoint to fetch public keys from.
+// - cacheTTL: Duration to keep the cached keys when the response has no Cache-Control max-age.
+func NewJWKSStore(jwksURL string, cacheTTL time.Duration) *JWKSStore {
+	return &JWKSStore{
+		jwksURL: jwksURL,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		cacheTTL: cacheTTL,
+	}
+}
+
+// SaveKey is not supported for JWKSStore as it's intended for fetching keys.
+func (js *JWKSStore) SaveKey(entry KeyEntry) error {
+	return fmt.Errorf("SaveKey is not supported by JWKSStore")
+}
+
+// PruneKeys is not supported for JWKSStore as it's intended for fetching keys.
+func (js *JWKSStore) PruneKeys(before time.Time) error {
+	return fmt.Errorf("PruneKeys is not supported by JWKSStore")
+}
+
+// GetAllKeys retrieves the public keys from the JWKS URL or from the cache if valid.
+func (js *JWKSStore) GetAllKeys() ([]KeyEntry, error) {
+	js.cacheMu.RLock()
+	if time.Now().Before(js.cacheExpiry) && js.cache != nil {
+		keysCopy := make([]KeyEntry, len(js.cache))
+		copy(keysCopy, js.cache)
+		js.cacheMu.RUnlock()
+		return keysCopy, nil
+	}
+	js.cacheMu.RUnlock()
+
+	resp, err := js.client.Get(js.jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	set, err := jwk.Parse(bodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %v", err)
+	}
+
+	fetchedKeys, err := keyEntriesFromSet(set)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := js.cacheTTL
+	if maxAge, ok := parseMaxAge(resp.Header.Get("Cache-Control")); ok {
+		ttl = maxAge
+	}
+
+	js.cacheMu.Lock()
+	js.cache = fetchedKeys
+	js.cacheExpiry = time.Now().Add(ttl)
+	js.cacheMu.Unlock()
+
+	keysCopy := make([]KeyEntry, len(fetchedKeys))
+	copy(keysCopy, fetchedKeys)
+	return keysCopy, nil
+}
+
+// keyEntriesFromSet converts the signature keys of a JWK Set to KeyEntry values,
+// sorted by expiry ascending like the other stores.
+func keyEntriesFromSet(set jwk.Set) ([]KeyEntry, error) {
+	var entries []KeyEntry
+
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+
+		var use string
+		if err := key.Get(jwk.KeyUsageKey, &use); err == nil && use != "sig" {
+			continue
+		}
+
+		var raw any
+		if err := jwk.Export(key, &raw); err != nil {
+			return nil, fmt.Errorf("failed to export key: %v", err)
+		}
+
+		publicKey, err := x509.MarshalPKIXPublicKey(raw)
+		if err != nil {
+			// Symmetric or private keys are not accepted from a JWKS
+			continue
+		}
+
+		var alg string
+		if keyAlg, ok := key.Algorithm(); ok {
+			alg = keyAlg.String()
+		} else if alg, ok = defaultSignatureAlg(raw); !ok {
+			continue
+		}
+
+		entry := KeyEntry{
+			Alg:       alg,
+			PublicKey: publicKey,
+		}
+
+		// Our issuers use the base64 encoded key info as kid
+		kid, _ := key.KeyID()
+		if entry.Info, err = base64.StdEncoding.DecodeString(kid); err != nil {
+			entry.Info = []byte(kid)
+		}
+
+		var exp float64
+		if err := key.Get("exp", &exp); err == nil {
+			entry.Expiry = time.Unix(int64(exp), 0)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Expiry.Before(entries[j].Expiry)
+	})
+
+	return entries, nil
+}
+
+// defaultSignatureAlg returns the algorithm of a public key published without
+// "alg", derived from its key type and curve.
+func defaultSignatureAlg(publicKey any) (string, bool) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return "RS256", true
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().Name {
+		case "P-256":
+			return "ES256", true
+		case "P-384":
+			return "ES384", true
+		case "P-521":
+			return "ES512", true
+		}
+	case ed25519.PublicKey:
+		return "EdDSA", true
+	}
+	return "", false
+}
+
+// parseMaxAge extracts the max-age directive of a Cache-Control header.
+// no-cache and no-store are reported as a zero max-age.
+func parseMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,200 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+// JWSOptions shares the options of JWEOptions for signed tokens.
+type JWSOptions = JWEOptions
+
+// IsJWS reports whether the token is in JWS compact serialization
+// (three dot separated parts) rather than JWE compact serialization (five).
+func IsJWS(token []byte) bool {
+	return bytes.Count(token, []byte{'.'}) == 2
+}
+
+// signingKeyGenerators maps the supported signature algorithms to their key pair generator.
+var signingKeyGenerators = map[string]func() (crypto.Signer, error){
+	jwa.RS256().String(): generateRSAKey,
+	jwa.RS384().String(): generateRSAKey,
+	jwa.RS512().String(): generateRSAKey,
+	jwa.PS256().String(): generateRSAKey,
+	jwa.PS384().String(): generateRSAKey,
+	jwa.PS512().String(): generateRSAKey,
+	jwa.ES256().String(): func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) },
+	jwa.ES384().String(): func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) },
+	jwa.ES512().String(): func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P521(), rand.Reader) },
+	jwa.EdDSA().String(): func() (crypto.Signer, error) {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	},
+}
+
+func generateRSAKey() (crypto.Signer, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// generateSigningKey creates a new asymmetric key pair for the given algorithm and
+// returns the PKCS#8 encoded private key and the PKIX encoded public key.
+func generateSigningKey(alg string) ([]byte, []byte, error) {
+	generate, ok := signingKeyGenerators[alg]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	privateKey, err := generate()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %v", err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	return privateDER, publicDER, nil
+}
+
+func signatureAlgorithm(alg string) (jwa.SignatureAlgorithm, error) {
+	sigAlg, ok := jwa.LookupSignatureAlgorithm(alg)
+	if !ok {
+		return jwa.EmptySignatureAlgorithm(), fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	return sigAlg, nil
+}
+
+// IssueJWS signs the payload with the current private key and returns a compact JWS.
+// The registered claims are added to JSON object payloads, other payloads are
+// signed as is and their claims can only be validated from the protected
+// headers, e.g. with VerifyJWSClaims.
+func (km *KeyManager) IssueJWS(payload []byte, opts *JWSOptions) ([]byte, error) {
+	if km.validationOnly {
+		return nil, fmt.Errorf("IssueJWS not allowed in validation only mode")
+	}
+	if km.signingAlg == "" {
+		return nil, fmt.Errorf("IssueJWS requires a signing key manager")
+	}
+
+	if err := km.ensureCurrentKey(); err != nil {
+		return nil, err
+	}
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	alg, err := signatureAlgorithm(km.currentKey.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(km.currentKey.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	headers := jws.NewHeaders()
+	headers.Set("kid", km.currentKey.KID())
+	registered, err := setClaimHeaders(headers, opts)
+	if err != nil {
+		return nil, err
+	}
+	if payload, err = addPayloadClaims(payload, registered); err != nil {
+		return nil, err
+	}
+
+	signed, err := jws.Sign(payload, jws.WithKey(alg, privateKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign JWS: %v", err)
+	}
+
+	return signed, nil
+}
+
+// VerifyJWS verifies a compact JWS against the known public keys and returns its payload.
+func (km *KeyManager) VerifyJWS(token []byte) ([]byte, error) {
+	verified, _, err := km.VerifyJWSClaims(token)
+	return verified, err
+}
+
+// VerifyJWSClaims verifies the JWS, validates its claims against the
+// ValidationPolicy and returns the payload together with the claims.
+func (km *KeyManager) VerifyJWSClaims(token []byte) ([]byte, *Claims, error) {
+	// Emitted once the read lock is released
+	var oldKID string
+	defer func() {
+		if oldKID != "" {
+			km.emit(KeyEvent{Type: OldKeyUsed, KID: oldKID})
+		}
+	}()
+
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	msg, err := jws.Parse(token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to parse JWS: %v", ErrMalformed, err)
+	}
+	if len(msg.Signatures()) != 1 {
+		return nil, nil, fmt.Errorf("%w: expected exactly one signature in JWS", ErrMalformed)
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+
+	// Tokens of third-party issuers may omit the kid, any signing key is tried then
+	var candidates []KeyEntry
+	if kid, ok := headers.KeyID(); ok && kid != "" {
+		keyEntry, ok := km.keysByKID[kid]
+		if !ok || keyEntry.PublicKey == nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+		}
+		candidates = []KeyEntry{keyEntry}
+	} else {
+		candidates = append([]KeyEntry{km.currentKey}, km.keyHistory...)
+	}
+
+	for _, keyEntry := range candidates {
+		if keyEntry.PublicKey == nil {
+			continue
+		}
+		alg, err := signatureAlgorithm(keyEntry.Alg)
+		if err != nil {
+			continue
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(keyEntry.PublicKey)
+		if err != nil {
+			continue
+		}
+		verified, err := jws.Verify(token, jws.WithKey(alg, publicKey))
+		if err != nil {
+			continue
+		}
+
+		// Claims are only trusted once the signature is verified. Claims in the
+		// payload take precedence, third-party issuers only set them there.
+		claims, present := claimsFromHeaders(claimSources{newPayloadClaims(verified), headers})
+		if err := km.policy.Validate(claims, present); err != nil {
+			return nil, nil, err
+		}
+
+		if keyEntry.KID() != km.currentKey.KID() {
+			oldKID = keyEntry.KID()
+		}
+		return verified, claims, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: failed to verify JWS", ErrTampered)
+}
@@ -0,0 +1,152 @@
+package jwt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tphan267/common/system"
+)
+
+// KeyEncryptionKey (KEK) wraps the keys persisted by a KeyStore with AES-256-GCM,
+// so a dump of the store does not leak the token secrets.
+type KeyEncryptionKey struct {
+	id  string
+	key []byte
+}
+
+// NewKeyEncryptionKey creates a KeyEncryptionKey from 32 random bytes.
+// Its ID is derived from the key, so stored keys record which KEK wraps them.
+func NewKeyEncryptionKey(key []byte) (*KeyEncryptionKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key-encryption-key must be 32 bytes, got %d", len(key))
+	}
+	sum := sha256.Sum256(key)
+	return &KeyEncryptionKey{
+		id:  hex.EncodeToString(sum[:8]),
+		key: key,
+	}, nil
+}
+
+// KeyEncryptionKeyFromEnv loads the KEK from the base64 encoded JWT_KEK
+// environment variable, or from the file named by JWT_KEK_FILE.
+func KeyEncryptionKeyFromEnv() (*KeyEncryptionKey, error) {
+	if encoded := system.Env("JWT_KEK"); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JWT_KEK: %v", err)
+		}
+		return NewKeyEncryptionKey(key)
+	}
+	if path := system.Env("JWT_KEK_FILE"); path != "" {
+		return KeyEncryptionKeyFromFile(path)
+	}
+	return nil, fmt.Errorf("no key-encryption-key configured, set JWT_KEK or JWT_KEK_FILE")
+}
+
+// KeyEncryptionKeyFromFile loads the KEK from a file holding the base64 encoded key.
+func KeyEncryptionKeyFromFile(path string) (*KeyEncryptionKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key-encryption-key file: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key-encryption-key file: %v", err)
+	}
+	return NewKeyEncryptionKey(key)
+}
+
+// ID identifies the KEK in the KEKID column of wrapped key entries.
+func (k *KeyEncryptionKey) ID() string {
+	return k.id
+}
+
+// wrap encrypts a key, the key info is authenticated so wrapped keys can't be swapped.
+// The result is the GCM nonce followed by the ciphertext.
+func (k *KeyEncryptionKey) wrap(plaintext, info []byte) ([]byte, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, info), nil
+}
+
+// unwrap decrypts a key wrapped by wrap.
+func (k *KeyEncryptionKey) unwrap(wrapped, info []byte) ([]byte, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	nonce, ciphertext := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %v", err)
+	}
+	return plaintext, nil
+}
+
+func (k *KeyEncryptionKey) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKey wraps the key of the entry with the first KEK, the current one.
+// Entries are kept in plaintext when no KEK is given.
+func wrapKey(keks []*KeyEncryptionKey, entry *KeyEntry) error {
+	if len(keks) == 0 || entry.Key == nil {
+		return nil
+	}
+	wrapped, err := keks[0].wrap(entry.Key, entry.Info)
+	if err != nil {
+		return err
+	}
+	entry.Key = wrapped
+	entry.KEKID = keks[0].ID()
+	return nil
+}
+
+// unwrapKey unwraps the key of the entry with the KEK that wrapped it.
+func unwrapKey(keks []*KeyEncryptionKey, entry *KeyEntry) error {
+	if entry.KEKID == "" {
+		return nil
+	}
+	for _, kek := range keks {
+		if kek.ID() != entry.KEKID {
+			continue
+		}
+		key, err := kek.unwrap(entry.Key, entry.Info)
+		if err != nil {
+			return err
+		}
+		entry.Key = key
+		entry.KEKID = ""
+		return nil
+	}
+	return fmt.Errorf("unknown key-encryption-key %q for key %d", entry.KEKID, entry.ID)
+}
+
+// needsRewrap reports whether the entry is not wrapped with the current KEK.
+func needsRewrap(keks []*KeyEncryptionKey, entry KeyEntry) bool {
+	return entry.Key != nil && entry.KEKID != keks[0].ID()
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
@@ -13,31 +14,95 @@ import (
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwe"
 	"golang.org/x/crypto/hkdf"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/tphan267/common/system"
 )
 
 type JWEOptions struct {
 	ExpiresIn time.Duration
+	Claims    *Claims
 	Headers   map[string]any
 }
 
+const (
+	// defaultRetainCount is the number of retired keys kept when no retention is configured.
+	defaultRetainCount = 2
+	// maxRotationCheckInterval bounds the interval between two rotation checks.
+	maxRotationCheckInterval = time.Minute
+	// rotationLockTTL bounds how long a crashed replica blocks rotation.
+	rotationLockTTL = 30 * time.Second
+	// initialKeyAttempts and initialKeyWait bound the wait for the initial key
+	// generated by another replica.
+	initialKeyAttempts = 50
+	initialKeyWait     = 100 * time.Millisecond
+	// issueKeyAttempts bounds the wait of IssueJWE and IssueJWS for the key
+	// rotated by another replica when the current key has expired.
+	issueKeyAttempts = 10
+	// defaultRefreshInterval is the background refresh interval of validation KeyManagers.
+	defaultRefreshInterval = 5 * time.Minute
+)
+
+// KeyManagerOptions tunes the key rotation, retention and refresh of KeyManagers.
+// A retired key is kept while it is one of the last RetainCount retired keys or
+// while it could have signed a still valid token according to MaxTokenLifetime.
+// Without retention settings the last 2 retired keys are kept. Retired keys
+// that are no longer kept are pruned from the store, so KeyManagers sharing a
+// store must use the same options.
+type KeyManagerOptions struct {
+	// RetainCount is the number of retired keys kept regardless of their age.
+	RetainCount int
+	// MaxTokenLifetime is the longest lifetime of the tokens issued with the
+	// KeyManager, retired keys are kept until it elapsed after their expiry.
+	MaxTokenLifetime time.Duration
+	// RefreshInterval is the interval between two background key refreshes of
+	// validation KeyManagers, 5 minutes by default. Up to 10% of jitter is added
+	// so that replicas don't hit the store at the same time.
+	RefreshInterval time.Duration
+	// UnknownKIDRefreshInterval is the minimum interval between two refreshes
+	// triggered by tokens with the same unknown kid, 30 seconds by default.
+	UnknownKIDRefreshInterval time.Duration
+}
+
 type KeyManager struct {
 	currentKey     KeyEntry
 	keyHistory     []KeyEntry
+	keysByKID      map[string]KeyEntry // Current and retained keys, see indexKeys
 	rotationPeriod time.Duration
 	mu             sync.RWMutex
 	store          KeyStore
 	validationOnly bool
+	signingAlg     string
+	policy         ValidationPolicy
+	options        KeyManagerOptions
+	id             string // Owner of the rotation lock
 	ctx            context.Context
 	cancel         context.CancelFunc
+
+	// State reported by Status, guarded by mu
+	lastRefresh      time.Time
+	lastRefreshErr   error
+	lastRotation     time.Time
+	lastRotationErr  error
+	rotationFailures int
+
+	subscribersMu  sync.RWMutex
+	subscribers    []keyEventSubscriber
+	nextSubscriber int
+
+	refreshGroup   singleflight.Group
+	kidRefreshMu   sync.Mutex
+	kidRefreshes   map[string]*kidRefresh
+	lastKIDRefresh *kidRefresh
 }
 
 type KeyResponse struct {
-	ID     uint      `json:"id,omitempty"` // Omit if not using GormKeyStore
-	Key    string    `json:"key"`          // Base64 encoded key
-	Info   string    `json:"info"`         // Base64 encoded info
-	Expiry time.Time `json:"expiry"`       // Expiration time
+	ID        uint      `json:"id,omitempty"`        // Omit if not using GormKeyStore
+	Key       string    `json:"key,omitempty"`       // Base64 encoded key, omitted for signing keys
+	Info      string    `json:"info"`                // Base64 encoded info
+	Expiry    time.Time `json:"expiry"`              // Expiration time
+	Alg       string    `json:"alg,omitempty"`       // Signature algorithm of signing keys
+	PublicKey string    `json:"publicKey,omitempty"` // Base64 encoded PKIX public key of signing keys
 }
 
 func WithKeyManager(keyManager *KeyManager, handler func(*fiber.Ctx, *KeyManager) error) func(c *fiber.Ctx) error {
@@ -47,57 +112,58 @@ func WithKeyManager(keyManager *KeyManager, handler func(*fiber.Ctx, *KeyManager
 }
 
 // NewIssuerKeyManager creates a new KeyManager in issuer & validation mode.
-func NewIssuerKeyManager(rotationPeriod time.Duration, store KeyStore) (*KeyManager, error) {
+// Replicas sharing a KeyStore that implements KeyLocker coordinate rotation,
+// only one of them generates the next key and the others load it from the store.
+func NewIssuerKeyManager(rotationPeriod time.Duration, store KeyStore, opts ...KeyManagerOptions) (*KeyManager, error) {
+	return newIssuerKeyManager(rotationPeriod, store, "", opts)
+}
+
+// NewSigningKeyManager creates a new KeyManager in issuer & validation mode that
+// rotates asymmetric key pairs for the given algorithm (e.g. RS256, ES256, EdDSA)
+// and issues signed JWS tokens. Validators only need the public half of each key.
+func NewSigningKeyManager(alg jwa.SignatureAlgorithm, rotationPeriod time.Duration, store KeyStore, opts ...KeyManagerOptions) (*KeyManager, error) {
+	if _, ok := signingKeyGenerators[alg.String()]; !ok {
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	return newIssuerKeyManager(rotationPeriod, store, alg.String(), opts)
+}
+
+func newIssuerKeyManager(rotationPeriod time.Duration, store KeyStore, signingAlg string, opts []KeyManagerOptions) (*KeyManager, error) {
+	id, err := generateTokenID()
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	km := &KeyManager{
 		rotationPeriod: rotationPeriod,
 		store:          store,
 		validationOnly: false,
+		signingAlg:     signingAlg,
+		id:             id,
 		ctx:            ctx,
 		cancel:         cancel,
 	}
-
-	// Fetch all existing keys from the store
-	keys, err := store.GetAllKeys()
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve keys from store: %v", err)
+	if len(opts) > 0 {
+		km.options = opts[0]
 	}
 
-	if len(keys) > 0 {
-		// Set the most recent key as the current key
-		km.currentKey = keys[len(keys)-1]
-		if len(keys) > 1 {
-			km.keyHistory = keys[:len(keys)-1]
-		}
-
-		if len(keys) > 1 {
-			// Determine the start index to keep only the last three keys
-			start := 0
-			if len(keys)-1 > 2 {
-				start = len(keys) - 1 - 2
-			}
-			km.keyHistory = keys[start : len(keys)-1]
-		} else {
-			km.keyHistory = keys
+	// Load the existing keys and generate an initial key when none is usable.
+	// Another replica may hold the rotation lock while generating it, so wait
+	// for its key to show up in the store.
+	for attempt := 0; ; attempt++ {
+		if err := km.rotate(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to initialize key manager: %v", err)
 		}
-
-		// Check if the current key is nearing expiration
-		timeUntilExpiry := time.Until(km.currentKey.Expiry)
-		// Define a threshold for rotation, e.g., rotate 10% before expiry
-		rotationThreshold := time.Duration(float64(km.rotationPeriod) * 0.1)
-		system.Logger.Info("Check if rotating key is required...")
-		if timeUntilExpiry <= rotationThreshold {
-			system.Logger.Info("Current key is nearing expiration. Rotating key...")
-			if err := km.rotateKey(); err != nil {
-				return nil, fmt.Errorf("failed to rotate key: %v", err)
-			}
+		if km.currentKey.Key != nil {
+			break
 		}
-	} else {
-		// No existing keys found; generate an initial key
-		system.Logger.Info("No existing keys found. Generating initial key...")
-		if err := km.rotateKey(); err != nil {
-			return nil, fmt.Errorf("failed to initialize key manager: %v", err)
+		if attempt == initialKeyAttempts {
+			cancel()
+			return nil, fmt.Errorf("failed to initialize key manager: no key available in store")
 		}
+		time.Sleep(initialKeyWait)
 	}
 
 	go km.startKeyRotation()
@@ -106,36 +172,278 @@ func NewIssuerKeyManager(rotationPeriod time.Duration, store KeyStore) (*KeyMana
 }
 
 // NewValidationKeyManager creates a new KeyManager in validation only mode,
-// retrieving keys from the provided store.
-func NewValidationKeyManager(store KeyStore) (*KeyManager, error) {
+// retrieving keys from the provided store. Keys are refreshed in the background
+// every RefreshInterval, and on demand for tokens signed with an unknown key,
+// see RefreshForToken.
+func NewValidationKeyManager(store KeyStore, opts ...KeyManagerOptions) (*KeyManager, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	km := &KeyManager{
 		store:          store,
 		validationOnly: true,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	if len(opts) > 0 {
+		km.options = opts[0]
 	}
 
 	err := km.RefreshKeys()
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
+	go km.startKeyRefresh()
+
 	return km, nil
 }
 
+// RefreshKeys reloads the keys from the store. Concurrent calls share a single fetch.
 func (km *KeyManager) RefreshKeys() error {
-	keys, err := km.store.GetAllKeys()
+	_, err, _ := km.refreshGroup.Do("refresh", func() (any, error) {
+		return nil, km.refreshKeys()
+	})
+	return err
+}
+
+func (km *KeyManager) refreshKeys() error {
+	if err := km.syncKeys(); err != nil {
+		return err
+	}
+
+	km.mu.RLock()
+	hasKeys := km.currentKey.Info != nil
+	km.mu.RUnlock()
+	if !hasKeys {
+		return km.refreshFailed(fmt.Errorf("no keys available in store for validation"))
+	}
+
+	return nil
+}
+
+// refreshFailed records a refresh failure for Status and emits RefreshFailed.
+func (km *KeyManager) refreshFailed(err error) error {
+	km.mu.Lock()
+	km.lastRefreshErr = err
+	km.mu.Unlock()
+	km.emit(KeyEvent{Type: RefreshFailed, Err: err})
+	return err
+}
+
+// syncKeys reloads the keys from the store, the most recent key becomes the
+// current key. Issuers only load the keys of their own kind.
+func (km *KeyManager) syncKeys() error {
+	storedKeys, err := km.store.GetAllKeys()
 	if err != nil {
-		return fmt.Errorf("failed to retrieve keys from store: %v", err)
+		return km.refreshFailed(fmt.Errorf("failed to retrieve keys from store: %v", err))
 	}
 
+	var keys []KeyEntry
+	for _, key := range storedKeys {
+		if km.validationOnly || (key.Alg == km.signingAlg && key.Key != nil) {
+			keys = append(keys, key)
+		}
+	}
+
+	// Stores may serve the keys in any order, e.g. RemoteStore current key first
+	slices.SortStableFunc(keys, func(a, b KeyEntry) int {
+		return a.Expiry.Compare(b.Expiry)
+	})
+
+	km.mu.Lock()
+	km.lastRefresh = time.Now()
+	km.lastRefreshErr = nil
 	if len(keys) == 0 {
-		return fmt.Errorf("no keys available in store for validation")
+		km.mu.Unlock()
+		return nil
+	}
+
+	currentKey, keyHistory := keys[len(keys)-1], keys[:len(keys)-1]
+	if !km.validationOnly {
+		keyHistory = km.retainedKeys(keyHistory)
+	}
+	// Keep the keys in use unless the store changed, tokens are processed concurrently
+	currentKID := currentKey.KID()
+	changed := currentKID != km.currentKey.KID() || !sameKIDs(keyHistory, km.keyHistory)
+	if changed {
+		km.currentKey = currentKey
+		km.keyHistory = keyHistory
+		km.indexKeys()
+	}
+	km.mu.Unlock()
+
+	if changed {
+		km.emit(KeyEvent{Type: KeysRefreshed, KID: currentKID})
+	}
+
+	return nil
+}
+
+// trimHistory drops the retired keys that are no longer retained, see KeyManagerOptions.
+// The caller must hold km.mu.
+func (km *KeyManager) trimHistory() {
+	km.keyHistory = km.retainedKeys(km.keyHistory)
+}
+
+// retainedKeys returns the retired keys of history that are still retained.
+// The caller must hold km.mu.
+func (km *KeyManager) retainedKeys(history []KeyEntry) []KeyEntry {
+	retainCount := km.options.RetainCount
+	if retainCount <= 0 && km.options.MaxTokenLifetime <= 0 {
+		retainCount = defaultRetainCount
+	}
+
+	// The history is sorted by expiry, drop the oldest keys first. A key signs
+	// tokens until it expires and those tokens live up to MaxTokenLifetime.
+	now := time.Now()
+	for len(history) > retainCount && (km.options.MaxTokenLifetime <= 0 ||
+		!history[0].Expiry.Add(km.options.MaxTokenLifetime).After(now)) {
+		history = history[1:]
+	}
+	return history
+}
+
+// sameKIDs reports whether both key lists hold the same keys in the same order.
+func sameKIDs(a []KeyEntry, b []KeyEntry) bool {
+	return slices.EqualFunc(a, b, func(x, y KeyEntry) bool {
+		return x.KID() == y.KID()
+	})
+}
+
+// indexKeys rebuilds the kid index of the current and retained keys.
+// The caller must hold km.mu.
+func (km *KeyManager) indexKeys() {
+	km.keysByKID = make(map[string]KeyEntry, 2*(len(km.keyHistory)+1))
+	for _, key := range km.keyHistory {
+		km.indexKey(key)
+	}
+	km.indexKey(km.currentKey)
+}
+
+func (km *KeyManager) indexKey(key KeyEntry) {
+	km.keysByKID[key.KID()] = key
+	// Keys of third-party JWKS use the raw kid as info
+	km.keysByKID[string(key.Info)] = key
+}
+
+// pruneKeys deletes the keys older than the retained keys from the store.
+func (km *KeyManager) pruneKeys() {
+	km.mu.RLock()
+	before := km.currentKey.Expiry
+	if len(km.keyHistory) > 0 {
+		before = km.keyHistory[0].Expiry
+	}
+	km.mu.RUnlock()
+
+	if err := km.store.PruneKeys(before); err != nil {
+		system.Logger.Errorf("Error pruning keys: %v", err)
+	}
+}
+
+// needsRotation reports whether there is no current key or it is nearing expiration.
+func (km *KeyManager) needsRotation() bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	if km.currentKey.Key == nil {
+		return true
+	}
+	// Rotate 10% before expiry
+	rotationThreshold := time.Duration(float64(km.rotationPeriod) * 0.1)
+	return time.Until(km.currentKey.Expiry) <= rotationThreshold
+}
+
+// rotate loads the keys from the store and rotates the current key when it is
+// nearing expiration. When the store implements KeyLocker only the replica
+// holding the rotation lock generates a key, the others pick it up on a later sync.
+func (km *KeyManager) rotate() (err error) {
+	defer func() {
+		km.mu.Lock()
+		km.lastRotationErr = err
+		if err != nil {
+			km.rotationFailures++
+		} else {
+			km.rotationFailures = 0
+		}
+		km.mu.Unlock()
+
+		if err != nil {
+			km.emit(KeyEvent{Type: RotationFailed, Err: err})
+		}
+	}()
+
+	if err := km.syncKeys(); err != nil {
+		return err
+	}
+	if !km.needsRotation() {
+		return nil
+	}
+
+	locker, ok := km.store.(KeyLocker)
+	if !ok {
+		return km.rotateAndPrune()
+	}
+
+	acquired, err := locker.AcquireRotationLock(km.id, rotationLockTTL)
+	if err != nil {
+		return fmt.Errorf("failed to acquire rotation lock: %v", err)
+	}
+	if !acquired {
+		return nil
+	}
+	defer func() {
+		if err := locker.ReleaseRotationLock(km.id); err != nil {
+			system.Logger.Errorf("Error releasing rotation lock: %v", err)
+		}
+	}()
+
+	// Another replica may have rotated before we got the lock
+	if err := km.syncKeys(); err != nil {
+		return err
+	}
+	if !km.needsRotation() {
+		return nil
+	}
+
+	return km.rotateAndPrune()
+}
+
+// ensureCurrentKey rotates an expired current key before issuing a token. When
+// another replica holds the rotation lock, the store is synced again until its
+// key shows up, tokens are never issued with an expired key.
+func (km *KeyManager) ensureCurrentKey() error {
+	for attempt := 0; ; attempt++ {
+		km.mu.RLock()
+		expired := time.Now().After(km.currentKey.Expiry)
+		km.mu.RUnlock()
+		if !expired {
+			return nil
+		}
+		if attempt == issueKeyAttempts {
+			return fmt.Errorf("current key has expired and no rotated key is available")
+		}
+		if attempt > 0 {
+			time.Sleep(initialKeyWait)
+		}
+
+		if err := km.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate key: %v", err)
+		}
 	}
+}
 
-	km.currentKey = keys[0]
-	if len(keys) > 1 {
-		km.keyHistory = keys[1:]
+func (km *KeyManager) rotateAndPrune() error {
+	system.Logger.Info("Current key is nearing expiration. Rotating key...")
+	if err := km.rotateKey(); err != nil {
+		return err
 	}
 
+	km.mu.RLock()
+	kid := km.currentKey.KID()
+	km.mu.RUnlock()
+	km.emit(KeyEvent{Type: KeyRotated, KID: kid})
+
+	km.pruneKeys()
 	return nil
 }
 
@@ -147,26 +455,19 @@ func (km *KeyManager) rotateKey() error {
 	km.mu.Lock()
 	defer km.mu.Unlock()
 
-	newKey := make([]byte, 32)
-	if _, err := rand.Read(newKey); err != nil {
-		return fmt.Errorf("failed to generate new key: %v", err)
+	newKey, err := km.generateKey()
+	if err != nil {
+		return err
 	}
 
-	newInfo := []byte(fmt.Sprintf("encryption-key-%d", time.Now().Unix()))
-	newExpiry := time.Now().Add(km.rotationPeriod)
-
 	if km.currentKey.Key != nil {
 		km.keyHistory = append(km.keyHistory, km.currentKey)
-		if len(km.keyHistory) > 3 {
-			km.keyHistory = km.keyHistory[1:len(km.keyHistory)]
-		}
+		km.trimHistory()
 	}
 
-	km.currentKey = KeyEntry{
-		Key:    newKey,
-		Info:   newInfo,
-		Expiry: newExpiry,
-	}
+	km.currentKey = newKey
+	km.indexKeys()
+	km.lastRotation = time.Now()
 
 	if err := km.store.SaveKey(km.currentKey); err != nil {
 		return fmt.Errorf("failed to save key: %v", err)
@@ -175,17 +476,45 @@ func (km *KeyManager) rotateKey() error {
 	return nil
 }
 
+func (km *KeyManager) generateKey() (KeyEntry, error) {
+	expiry := time.Now().Add(km.rotationPeriod)
+
+	if km.signingAlg != "" {
+		privateKey, publicKey, err := generateSigningKey(km.signingAlg)
+		if err != nil {
+			return KeyEntry{}, err
+		}
+		return KeyEntry{
+			Key:       privateKey,
+			Info:      []byte(fmt.Sprintf("signing-key-%d", time.Now().UnixNano())),
+			Expiry:    expiry,
+			Alg:       km.signingAlg,
+			PublicKey: publicKey,
+		}, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return KeyEntry{}, fmt.Errorf("failed to generate new key: %v", err)
+	}
+
+	return KeyEntry{
+		Key:    key,
+		Info:   []byte(fmt.Sprintf("encryption-key-%d", time.Now().UnixNano())),
+		Expiry: expiry,
+	}, nil
+}
+
 func (km *KeyManager) startKeyRotation() {
-	ticker := time.NewTicker(km.rotationPeriod)
+	// Check often enough to pick up keys rotated by other replicas in time
+	ticker := time.NewTicker(min(km.rotationPeriod/10, maxRotationCheckInterval))
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := km.rotateKey(); err != nil {
+			if err := km.rotate(); err != nil {
 				system.Logger.Errorf("Error rotating key: %v", err)
-			} else {
-				system.Logger.Infof("Key rotated successfully.")
 			}
 		case <-km.ctx.Done():
 			system.Logger.Infof("Key rotation goroutine shutting down.")
@@ -198,25 +527,15 @@ func (km *KeyManager) GetCurrentKeys() ([]KeyEntry, error) {
 	km.mu.RLock()
 	defer km.mu.RUnlock()
 
-	var currentKeys []KeyEntry
-
-	// Start with the current key
-	currentKeys = append(currentKeys, km.currentKey)
-
-	// Determine how many historical keys to include
-	historyCount := 2 // since we already have the current key
-	if len(km.keyHistory) < historyCount {
-		historyCount = len(km.keyHistory)
-	}
-
-	// Append the required number of historical keys
-	if historyCount > 0 {
-		currentKeys = append(currentKeys, km.keyHistory[len(km.keyHistory)-historyCount:]...)
-	}
+	// Start with the current key, followed by the retained keys
+	currentKeys := append([]KeyEntry{km.currentKey}, km.keyHistory...)
 
 	return currentKeys, nil
 }
 
+// GetCurrentKeysAPIHandler returns the current keys in the KeyResponse format.
+//
+// Deprecated: it exposes raw symmetric keys, use JWKSAPIHandler to publish public keys.
 func (km *KeyManager) GetCurrentKeysAPIHandler(c *fiber.Ctx) error {
 	keys, err := km.GetCurrentKeys()
 	if err != nil {
@@ -229,10 +548,17 @@ func (km *KeyManager) GetCurrentKeysAPIHandler(c *fiber.Ctx) error {
 	for _, key := range keys {
 		kr := KeyResponse{
 			Expiry: key.Expiry,
-			Key:    base64.StdEncoding.EncodeToString(key.Key),
 			Info:   base64.StdEncoding.EncodeToString(key.Info),
 		}
 
+		// Never expose private keys, signing keys only publish their public half
+		if key.PublicKey != nil {
+			kr.Alg = key.Alg
+			kr.PublicKey = base64.StdEncoding.EncodeToString(key.PublicKey)
+		} else {
+			kr.Key = base64.StdEncoding.EncodeToString(key.Key)
+		}
+
 		// Include ID only if it's set (useful for GormKeyStore)
 		if key.ID != 0 {
 			kr.ID = key.ID
@@ -269,15 +595,14 @@ func (km *KeyManager) IssueJWE(payload []byte, opts *JWEOptions) ([]byte, error)
 	if km.validationOnly {
 		return nil, fmt.Errorf("IssueJWE not allowed in validation only mode")
 	}
+	if km.signingAlg != "" {
+		return nil, fmt.Errorf("IssueJWE not allowed in signing mode, use IssueJWS")
+	}
 
-	km.mu.RLock()
-	if time.Now().After(km.currentKey.Expiry) {
-		km.mu.RUnlock()
-		if err := km.rotateKey(); err != nil {
-			return nil, fmt.Errorf("failed to rotate key: %v", err)
-		}
-		km.mu.RLock()
+	if err := km.ensureCurrentKey(); err != nil {
+		return nil, err
 	}
+	km.mu.RLock()
 	defer km.mu.RUnlock()
 
 	salt, err := generateSalt()
@@ -292,16 +617,9 @@ func (km *KeyManager) IssueJWE(payload []byte, opts *JWEOptions) ([]byte, error)
 
 	headers := jwe.NewHeaders()
 	headers.Set("salt", base64.StdEncoding.EncodeToString(salt))
-	headers.Set("kid", base64.StdEncoding.EncodeToString(km.currentKey.Info))
-	headers.Set("iat", time.Now().Unix())
-
-	if opts != nil {
-		if opts.ExpiresIn > 0 {
-			headers.Set("exp", time.Now().Add(opts.ExpiresIn).Unix())
-		}
-		for k, v := range opts.Headers {
-			headers.Set(k, v)
-		}
+	headers.Set("kid", km.currentKey.KID())
+	if _, err := setClaimHeaders(headers, opts); err != nil {
+		return nil, err
 	}
 
 	encrypted, err := jwe.Encrypt(
@@ -318,45 +636,94 @@ func (km *KeyManager) IssueJWE(payload []byte, opts *JWEOptions) ([]byte, error)
 }
 
 func (km *KeyManager) DecryptJWE(token []byte) ([]byte, error) {
+	decrypted, _, err := km.DecryptJWEClaims(token)
+	return decrypted, err
+}
+
+// DecryptJWEClaims decrypts the JWE, validates its claims against the
+// ValidationPolicy and returns the payload together with the claims.
+func (km *KeyManager) DecryptJWEClaims(token []byte) ([]byte, *Claims, error) {
+	// Emitted once the read lock is released
+	var oldKID string
+	defer func() {
+		if oldKID != "" {
+			km.emit(KeyEvent{Type: OldKeyUsed, KID: oldKID})
+		}
+	}()
+
 	km.mu.RLock()
 	defer km.mu.RUnlock()
 
 	msg, err := jwe.Parse(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse JWE: %v", err)
+		return nil, nil, fmt.Errorf("%w: failed to parse JWE: %v", ErrMalformed, err)
 	}
-
 	headers := msg.ProtectedHeaders()
-	var expiration float64
-	if err := headers.Get("exp", &expiration); err == nil {
-		expTime := time.Unix(int64(expiration), 0)
-		if time.Now().After(expTime) {
-			return nil, fmt.Errorf("token has expired")
-		}
+
+	kid, ok := headers.KeyID()
+	if !ok || kid == "" {
+		return nil, nil, fmt.Errorf("%w: missing kid header", ErrMalformed)
+	}
+	keyEntry, ok := km.keysByKID[kid]
+	if !ok || keyEntry.Alg != "" {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
 	}
 
 	var saltStr string
 	if err := headers.Get("salt", &saltStr); err != nil {
-		return nil, fmt.Errorf("failed to get salt from headers: %v", err)
+		return nil, nil, fmt.Errorf("%w: failed to get salt from headers: %v", ErrMalformed, err)
 	}
 
 	saltBytes, err := base64.StdEncoding.DecodeString(saltStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode salt: %v", err)
+		return nil, nil, fmt.Errorf("%w: failed to decode salt: %v", ErrMalformed, err)
 	}
 
-	allKeys := append([]KeyEntry{km.currentKey}, km.keyHistory...)
+	derivedKey, err := deriveKey(keyEntry.Key, saltBytes, keyEntry.Info)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	for _, keyEntry := range allKeys {
-		derivedKey, err := deriveKey(keyEntry.Key, saltBytes, keyEntry.Info)
-		if err != nil {
-			continue
-		}
-		decrypted, err := jwe.Decrypt(token, jwe.WithKey(jwa.DIRECT(), derivedKey))
-		if err == nil {
-			return decrypted, nil
-		}
+	decrypted, err := jwe.Decrypt(token, jwe.WithKey(jwa.DIRECT(), derivedKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to decrypt JWE: %v", ErrTampered, err)
 	}
 
-	return nil, fmt.Errorf("failed to decrypt JWE with any known key")
+	// Claims are only trusted once the token is authenticated
+	claims, present := claimsFromHeaders(headers)
+	if err := km.policy.Validate(claims, present); err != nil {
+		return nil, nil, err
+	}
+
+	if keyEntry.KID() != km.currentKey.KID() {
+		oldKID = keyEntry.KID()
+	}
+	return decrypted, claims, nil
+}
+
+// IsSigning reports whether the KeyManager issues signed JWS tokens instead of JWE.
+func (km *KeyManager) IsSigning() bool {
+	return km.signingAlg != ""
+}
+
+// IssueToken issues a JWS in signing mode, a JWE otherwise.
+func (km *KeyManager) IssueToken(payload []byte, opts *JWEOptions) ([]byte, error) {
+	if km.IsSigning() {
+		return km.IssueJWS(payload, opts)
+	}
+	return km.IssueJWE(payload, opts)
+}
+
+// VerifyToken verifies a JWS or decrypts a JWE depending on the token format.
+func (km *KeyManager) VerifyToken(token []byte) ([]byte, error) {
+	payload, _, err := km.VerifyTokenClaims(token)
+	return payload, err
+}
+
+// VerifyTokenClaims is VerifyToken that also returns the validated claims.
+func (km *KeyManager) VerifyTokenClaims(token []byte) ([]byte, *Claims, error) {
+	if IsJWS(token) {
+		return km.VerifyJWSClaims(token)
+	}
+	return km.DecryptJWEClaims(token)
 }
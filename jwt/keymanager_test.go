@@ -2,11 +2,20 @@
 package jwt
 
 import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	jwxjwt "github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tphan267/common/system"
@@ -59,7 +68,13 @@ func TestKeyManager_KeyRotation(t *testing.T) {
 	km, err := NewIssuerKeyManager(rotationPeriod, store)
 	require.NoError(t, err, "Failed to create KeyManager")
 
-	initialKey := km.currentKey
+	currentKey := func() KeyEntry {
+		km.mu.RLock()
+		defer km.mu.RUnlock()
+		return km.currentKey
+	}
+
+	initialKey := currentKey()
 	require.NotNil(t, initialKey.Key, "Initial key should not be nil")
 
 	// Wait for rotation to occur
@@ -70,12 +85,395 @@ func TestKeyManager_KeyRotation(t *testing.T) {
 	require.NoError(t, err, "GetAllKeys should not return an error")
 	require.Len(t, keys, 2, "There should be two keys after rotation")
 
-	newKey := km.currentKey
+	newKey := currentKey()
 	assert.NotEqual(t, initialKey.Key, newKey.Key, "New key should be different from the initial key")
 	assert.Equal(t, initialKey, keys[0], "Initial key should be in the key history")
 	assert.Equal(t, newKey, keys[1], "New key should be the current key")
 }
 
+func TestKeyManager_CoordinatedRotation(t *testing.T) {
+	// The stored key is nearing expiration, both replicas want to rotate it
+	store := NewInMemoryKeyStore()
+	require.NoError(t, store.SaveKey(KeyEntry{
+		Key:    bytes.Repeat([]byte{1}, 32),
+		Info:   []byte("encryption-key-old"),
+		Expiry: time.Now().Add(time.Minute),
+	}))
+
+	// Another replica holds the rotation lock
+	acquired, err := store.AcquireRotationLock("other", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	rotationPeriod := time.Hour
+	km1, err := NewIssuerKeyManager(rotationPeriod, store)
+	require.NoError(t, err, "Failed to create first KeyManager")
+	defer km1.Shutdown()
+	km2, err := NewIssuerKeyManager(rotationPeriod, store)
+	require.NoError(t, err, "Failed to create second KeyManager")
+	defer km2.Shutdown()
+
+	currentKID := func(km *KeyManager) string {
+		km.mu.RLock()
+		defer km.mu.RUnlock()
+		return km.currentKey.KID()
+	}
+
+	// Replicas don't rotate without the lock
+	require.NoError(t, km1.rotate())
+	require.NoError(t, km2.rotate())
+	keys, err := store.GetAllKeys()
+	require.NoError(t, err, "GetAllKeys should not return an error")
+	assert.Len(t, keys, 1, "Replicas should not rotate without the rotation lock")
+	assert.Equal(t, currentKID(km1), currentKID(km2), "Replicas should share the initial key")
+
+	// Only one replica rotates once the lock is released
+	require.NoError(t, store.ReleaseRotationLock("other"))
+	var wg sync.WaitGroup
+	for _, km := range []*KeyManager{km1, km2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, km.rotate())
+		}()
+	}
+	wg.Wait()
+
+	keys, err = store.GetAllKeys()
+	require.NoError(t, err, "GetAllKeys should not return an error")
+	assert.Len(t, keys, 2, "Replicas should not rotate independently")
+
+	// The other replica picks up the new key on its next sync
+	require.NoError(t, km1.rotate())
+	require.NoError(t, km2.rotate())
+	assert.Equal(t, currentKID(km1), currentKID(km2), "Replicas should share the current key")
+	assert.Equal(t, keys[1].KID(), currentKID(km1))
+
+	// Tokens issued by one replica are accepted by the other
+	token, err := km1.IssueJWE([]byte("payload"), nil)
+	require.NoError(t, err, "IssueJWE should not return an error")
+	decrypted, err := km2.DecryptJWE(token)
+	require.NoError(t, err, "DecryptJWE should not return an error")
+	assert.Equal(t, []byte("payload"), decrypted)
+}
+
+func TestKeyManager_IssueWithExpiredKey(t *testing.T) {
+	store := NewInMemoryKeyStore()
+	require.NoError(t, store.SaveKey(KeyEntry{
+		Key:    bytes.Repeat([]byte{1}, 32),
+		Info:   []byte("encryption-key-old"),
+		Expiry: time.Now().Add(50 * time.Millisecond),
+	}))
+
+	// Another replica holds the rotation lock and doesn't rotate
+	acquired, err := store.AcquireRotationLock("other", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	km, err := NewIssuerKeyManager(time.Hour, store)
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+	time.Sleep(60 * time.Millisecond)
+
+	start := time.Now()
+	_, err = km.IssueJWE([]byte("payload"), nil)
+	assert.Error(t, err, "Tokens should not be issued with an expired key")
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// The key rotated by the other replica is picked up while waiting
+	rotated := KeyEntry{
+		Key:    bytes.Repeat([]byte{2}, 32),
+		Info:   []byte("encryption-key-new"),
+		Expiry: time.Now().Add(time.Hour),
+	}
+	time.AfterFunc(150*time.Millisecond, func() { store.SaveKey(rotated) })
+	token, err := km.IssueJWE([]byte("payload"), nil)
+	require.NoError(t, err, "IssueJWE should use the key rotated by the other replica")
+
+	payload, err := km.VerifyToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), payload)
+	km.mu.RLock()
+	assert.Equal(t, rotated.KID(), km.currentKey.KID())
+	km.mu.RUnlock()
+}
+
+func TestKeyManager_HistoryRetention(t *testing.T) {
+	store := NewInMemoryKeyStore()
+	for i := 6; i > 0; i-- {
+		store.SaveKey(KeyEntry{
+			Key:    []byte("key" + strconv.Itoa(i)),
+			Info:   []byte("info" + strconv.Itoa(i)),
+			Expiry: time.Now().Add(-time.Duration(i) * time.Hour),
+		})
+	}
+
+	km, err := NewIssuerKeyManager(time.Hour, store, KeyManagerOptions{
+		MaxTokenLifetime: 150 * time.Minute,
+	})
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	// Only keys expired less than MaxTokenLifetime ago are kept
+	require.Len(t, km.keyHistory, 2)
+	assert.Equal(t, []byte("key2"), km.keyHistory[0].Key)
+	assert.Equal(t, []byte("key1"), km.keyHistory[1].Key)
+}
+
+func TestKeyManager_RetentionOptions(t *testing.T) {
+	store := NewInMemoryKeyStore()
+	for i := 6; i > 0; i-- {
+		store.SaveKey(KeyEntry{
+			Key:    []byte("key" + strconv.Itoa(i)),
+			Info:   []byte("info" + strconv.Itoa(i)),
+			Expiry: time.Now().Add(-time.Duration(i) * time.Hour),
+		})
+	}
+
+	// All keys are expired, the KeyManager rotates and prunes the store
+	km, err := NewIssuerKeyManager(time.Hour, store, KeyManagerOptions{
+		RetainCount:      3,
+		MaxTokenLifetime: 90 * time.Minute,
+	})
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	keys, err := km.GetCurrentKeys()
+	require.NoError(t, err, "GetCurrentKeys should not return an error")
+	require.Len(t, keys, 4, "Current key and the last 3 retired keys should be returned")
+	assert.Equal(t, []byte("key3"), keys[1].Key)
+	assert.Equal(t, []byte("key1"), keys[3].Key)
+
+	stored, err := store.GetAllKeys()
+	require.NoError(t, err, "GetAllKeys should not return an error")
+	assert.Len(t, stored, 4, "Keys that are no longer retained should be pruned")
+	assert.Equal(t, []byte("key3"), stored[0].Key)
+
+	// Retention by age keeps more keys than RetainCount
+	km.mu.Lock()
+	km.options = KeyManagerOptions{RetainCount: 1, MaxTokenLifetime: 150 * time.Minute}
+	km.trimHistory()
+	km.mu.Unlock()
+	assert.Len(t, km.keyHistory, 2, "Keys expired less than MaxTokenLifetime ago should be kept")
+}
+
+// failingKeyStore fails GetAllKeys while err is set.
+type failingKeyStore struct {
+	KeyStore
+	err error
+}
+
+func (s *failingKeyStore) GetAllKeys() ([]KeyEntry, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.KeyStore.GetAllKeys()
+}
+
+func TestKeyManager_EventsAndStatus(t *testing.T) {
+	store := &failingKeyStore{KeyStore: NewInMemoryKeyStore()}
+	km, err := NewIssuerKeyManager(time.Hour, store)
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	validator, err := NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	var events []KeyEvent
+	unsubscribe := km.Subscribe(func(event KeyEvent) {
+		events = append(events, event)
+	})
+	var validatorEvents []KeyEvent
+	validator.Subscribe(func(event KeyEvent) {
+		validatorEvents = append(validatorEvents, event)
+	})
+
+	oldKID := km.Status().KID
+	token, err := km.IssueJWE([]byte("payload"), nil)
+	require.NoError(t, err)
+
+	// Rotation
+	require.NoError(t, km.rotateAndPrune())
+	status := km.Status()
+	require.NotEmpty(t, events)
+	assert.Equal(t, KeyRotated, events[0].Type)
+	assert.Equal(t, status.KID, events[0].KID)
+	assert.NotEqual(t, oldKID, status.KID)
+	assert.Equal(t, 1, status.HistorySize)
+	assert.False(t, status.LastRotation.IsZero())
+
+	// Validators pick up the new key
+	require.NoError(t, validator.RefreshKeys())
+	require.Len(t, validatorEvents, 1)
+	assert.Equal(t, KeysRefreshed, validatorEvents[0].Type)
+	assert.Equal(t, status.KID, validatorEvents[0].KID)
+
+	// Decryption with the retired key
+	events = nil
+	_, err = km.DecryptJWE(token)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, OldKeyUsed, events[0].Type)
+	assert.Equal(t, oldKID, events[0].KID)
+
+	// Failures are reported until the store recovers
+	events = nil
+	store.err = fmt.Errorf("store unavailable")
+	assert.Error(t, km.rotate())
+	require.Len(t, events, 2)
+	assert.Equal(t, RefreshFailed, events[0].Type)
+	assert.Equal(t, RotationFailed, events[1].Type)
+	assert.ErrorContains(t, events[1].Err, "store unavailable")
+
+	status = km.Status()
+	assert.Contains(t, status.LastRefreshError, "store unavailable")
+	assert.Contains(t, status.LastRotationError, "store unavailable")
+	assert.Equal(t, 1, status.RotationFailures)
+
+	store.err = nil
+	require.NoError(t, km.rotate())
+	status = km.Status()
+	assert.Empty(t, status.LastRefreshError)
+	assert.Empty(t, status.LastRotationError)
+	assert.Zero(t, status.RotationFailures)
+
+	// No more events after unsubscribing
+	events = nil
+	unsubscribe()
+	require.NoError(t, km.rotateAndPrune())
+	assert.Empty(t, events)
+}
+
+// countingKeyStore counts the GetAllKeys calls.
+type countingKeyStore struct {
+	KeyStore
+	fetches atomic.Int32
+}
+
+func (s *countingKeyStore) GetAllKeys() ([]KeyEntry, error) {
+	s.fetches.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return s.KeyStore.GetAllKeys()
+}
+
+func TestKeyManager_RefreshForToken(t *testing.T) {
+	store := NewInMemoryKeyStore()
+	issuer, err := NewIssuerKeyManager(time.Hour, store)
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer issuer.Shutdown()
+
+	counting := &countingKeyStore{KeyStore: store}
+	validator, err := NewValidationKeyManager(counting, KeyManagerOptions{RefreshInterval: time.Hour})
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	defer validator.Shutdown()
+
+	// Tokens of a key rotated after the last refresh are accepted after one refresh
+	require.NoError(t, issuer.rotateAndPrune())
+	token, err := issuer.IssueJWE([]byte("payload"), nil)
+	require.NoError(t, err)
+	_, err = validator.DecryptJWE(token)
+	require.Error(t, err, "The new key should not be known yet")
+
+	counting.fetches.Store(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, validator.RefreshForToken(token), "The kid should be known after the refresh")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), counting.fetches.Load(), "Concurrent refreshes should share one fetch")
+
+	decrypted, err := validator.DecryptJWE(token)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), decrypted)
+
+	// Known kids never trigger a refresh
+	assert.False(t, validator.RefreshForToken(token))
+	assert.Equal(t, int32(1), counting.fetches.Load())
+
+	// Unknown kids are rate limited
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"kid":"unknown"}`))
+	garbage := []byte(header + ".x.y.z.w")
+	time.Sleep(minKIDRefreshInterval)
+	for i := 0; i < 5; i++ {
+		assert.False(t, validator.RefreshForToken(garbage))
+	}
+	assert.Equal(t, int32(2), counting.fetches.Load(), "An unknown kid should trigger one refresh")
+}
+
+func TestKeyManager_BackgroundRefresh(t *testing.T) {
+	store := NewInMemoryKeyStore()
+	issuer, err := NewIssuerKeyManager(time.Hour, store)
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer issuer.Shutdown()
+
+	validator, err := NewValidationKeyManager(store, KeyManagerOptions{RefreshInterval: 100 * time.Millisecond})
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	defer validator.Shutdown()
+
+	require.NoError(t, issuer.rotateAndPrune())
+	token, err := issuer.IssueJWE([]byte("payload"), nil)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, err := validator.DecryptJWE(token)
+		return err == nil
+	}, time.Second, 50*time.Millisecond, "The validator should pick up the new key in the background")
+}
+
+func TestKeyManager_TypedErrors(t *testing.T) {
+	km, err := NewIssuerKeyManager(time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+	signer, err := NewSigningKeyManager(jwa.ES256(), time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer signer.Shutdown()
+	other, err := NewIssuerKeyManager(time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create other KeyManager")
+	defer other.Shutdown()
+
+	issue := func(km *KeyManager, opts *JWEOptions) []byte {
+		token, err := km.IssueToken([]byte("payload"), opts)
+		require.NoError(t, err)
+		return token
+	}
+	tamper := func(token []byte) []byte {
+		tampered := bytes.Clone(token)
+		i := bytes.LastIndexByte(tampered, '.') - 2
+		if tampered[i] == 'A' {
+			tampered[i] = 'B'
+		} else {
+			tampered[i] = 'A'
+		}
+		return tampered
+	}
+
+	tests := []struct {
+		name     string
+		km       *KeyManager
+		token    []byte
+		expected error
+	}{
+		{"malformed", km, []byte("not.a.token.at.all"), ErrMalformed},
+		{"unknown key", km, issue(other, nil), ErrUnknownKey},
+		{"tampered JWE", km, tamper(issue(km, nil)), ErrTampered},
+		{"tampered JWS", signer, tamper(issue(signer, nil)), ErrTampered},
+		{"JWS of unknown key", signer, issue(other, nil), ErrUnknownKey},
+		{"expired", km, issue(km, &JWEOptions{Headers: map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}}), ErrTokenExpired},
+		{"not yet valid", signer, issue(signer, &JWEOptions{Claims: &Claims{NotBefore: time.Now().Add(time.Hour)}}), ErrTokenNotYetValid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.km.VerifyToken(tt.token)
+			assert.ErrorIs(t, err, tt.expected)
+		})
+	}
+}
+
 func TestKeyManager_ValidationOnlyMode(t *testing.T) {
 	store := NewInMemoryKeyStore()
 
@@ -356,3 +754,190 @@ func TestGetCurrentKeys_WithMoreThanFourHistoryKeys(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyManager_IssueAndVerifyJWS(t *testing.T) {
+	for _, alg := range []jwa.SignatureAlgorithm{jwa.RS256(), jwa.ES256(), jwa.EdDSA()} {
+		t.Run(alg.String(), func(t *testing.T) {
+			store := NewInMemoryKeyStore()
+
+			km, err := NewSigningKeyManager(alg, 1*time.Hour, store)
+			require.NoError(t, err, "Failed to create signing KeyManager")
+			defer km.Shutdown()
+
+			payload := []byte("signed payload")
+			token, err := km.IssueJWS(payload, &JWSOptions{ExpiresIn: 30 * time.Minute})
+			require.NoError(t, err, "IssueJWS should not return an error")
+			assert.True(t, IsJWS(token), "Issued token should be a compact JWS")
+
+			verified, err := km.VerifyJWS(token)
+			assert.NoError(t, err, "VerifyJWS should not return an error")
+			assert.Equal(t, payload, verified, "Verified payload should match original payload")
+
+			_, err = km.IssueJWE(payload, nil)
+			assert.Error(t, err, "IssueJWE should return an error in signing mode")
+		})
+	}
+}
+
+func TestKeyManager_VerifyJWS_WithPublicKeysOnly(t *testing.T) {
+	store := NewInMemoryKeyStore()
+
+	kmIssuer, err := NewSigningKeyManager(jwa.ES256(), 1*time.Hour, store)
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer kmIssuer.Shutdown()
+
+	payload := []byte("public key payload")
+	token, err := kmIssuer.IssueToken(payload, nil)
+	require.NoError(t, err, "IssueToken should not return an error")
+
+	// Distribute only the public half of the keys to the validator
+	publicStore := NewInMemoryKeyStore()
+	keys, err := store.GetAllKeys()
+	require.NoError(t, err, "GetAllKeys should not return an error")
+	for _, key := range keys {
+		key.Key = nil
+		require.NoError(t, publicStore.SaveKey(key))
+	}
+
+	kmValidator, err := NewValidationKeyManager(publicStore)
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	verified, err := kmValidator.VerifyToken(token)
+	assert.NoError(t, err, "VerifyToken should not return an error with public keys only")
+	assert.Equal(t, payload, verified, "Verified payload should match original payload")
+
+	// Tamper with the signature
+	tampered := make([]byte, len(token))
+	copy(tampered, token)
+	tampered[len(tampered)-2] ^= 0x01
+
+	_, err = kmValidator.VerifyToken(tampered)
+	assert.Error(t, err, "VerifyToken should return an error for a tampered token")
+}
+
+func TestKeyManager_JWSPayloadClaims(t *testing.T) {
+	km, err := NewSigningKeyManager(jwa.ES256(), 1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer km.Shutdown()
+	km.SetValidationPolicy(ValidationPolicy{Audiences: []string{"service-a"}})
+
+	token, err := km.IssueJWS([]byte(`{"id":42,"typ":"refresh"}`), &JWSOptions{
+		ExpiresIn: 30 * time.Minute,
+		Claims:    &Claims{Issuer: "auth-service", Subject: "42", Audience: []string{"service-a"}, Type: "access"},
+	})
+	require.NoError(t, err, "IssueJWS should not return an error")
+
+	// Standard JWT libraries read the registered claims from the payload
+	parsed, err := jwxjwt.ParseInsecure(token)
+	require.NoError(t, err, "Token should be a valid JWT")
+	issuer, _ := parsed.Issuer()
+	assert.Equal(t, "auth-service", issuer)
+	audience, _ := parsed.Audience()
+	assert.Equal(t, []string{"service-a"}, audience)
+	expiration, ok := parsed.Expiration()
+	require.True(t, ok, "Payload should carry exp")
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), expiration, 2*time.Second)
+	jti, ok := parsed.JwtID()
+	require.True(t, ok, "Payload should carry jti")
+
+	payload, claims, err := km.VerifyJWSClaims(token)
+	require.NoError(t, err, "VerifyJWSClaims should not return an error")
+	assert.Equal(t, jti, claims.ID, "Header and payload claims should match")
+	assert.Equal(t, "access", claims.Type, "typ should only be read from the headers")
+	assert.Contains(t, string(payload), `"id":42`)
+
+	// Claims of third-party tokens are only in the payload
+	km.mu.RLock()
+	privateKey, err := x509.ParsePKCS8PrivateKey(km.currentKey.Key)
+	kid := km.currentKey.KID()
+	km.mu.RUnlock()
+	require.NoError(t, err)
+	headers := jws.NewHeaders()
+	headers.Set("kid", kid)
+	sign := func(payload string) []byte {
+		token, err := jws.Sign([]byte(payload), jws.WithKey(jwa.ES256(), privateKey, jws.WithProtectedHeaders(headers)))
+		require.NoError(t, err)
+		return token
+	}
+
+	expired := fmt.Sprintf(`{"aud":"service-a","exp":%d}`, time.Now().Add(-time.Hour).Unix())
+	_, err = km.VerifyJWS(sign(expired))
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	_, err = km.VerifyJWS(sign(`{"aud":"service-b"}`))
+	assert.ErrorIs(t, err, ErrInvalidClaims)
+}
+
+func TestKeyManager_ClaimsValidation(t *testing.T) {
+	store := NewInMemoryKeyStore()
+
+	km, err := NewIssuerKeyManager(1*time.Hour, store)
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+
+	kmValidator, err := NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+	kmValidator.SetValidationPolicy(ValidationPolicy{
+		Issuer:         "auth-service",
+		Audiences:      []string{"service-a"},
+		Leeway:         5 * time.Second,
+		RequiredClaims: []string{"exp", "sub", "jti"},
+	})
+
+	payload := []byte("payload with claims")
+	issue := func(claims Claims, expiresIn time.Duration) []byte {
+		token, err := km.IssueJWE(payload, &JWEOptions{ExpiresIn: expiresIn, Claims: &claims})
+		require.NoError(t, err, "IssueJWE should not return an error")
+		return token
+	}
+	valid := Claims{
+		Issuer:   "auth-service",
+		Subject:  "42",
+		Audience: []string{"service-a", "service-c"},
+		ID:       "token-1",
+	}
+
+	decrypted, claims, err := kmValidator.DecryptJWEClaims(issue(valid, time.Minute))
+	require.NoError(t, err, "DecryptJWEClaims should not return an error for valid claims")
+	assert.Equal(t, payload, decrypted)
+	assert.Equal(t, "auth-service", claims.Issuer)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, []string{"service-a", "service-c"}, claims.Audience)
+	assert.Equal(t, "token-1", claims.ID)
+	assert.False(t, claims.IssuedAt.IsZero(), "iat should be parsed")
+	assert.False(t, claims.ExpiresAt.IsZero(), "exp should be parsed")
+
+	// Tokens minted for another service must be rejected
+	otherAudience := valid
+	otherAudience.Audience = []string{"service-b"}
+	_, err = kmValidator.DecryptJWE(issue(otherAudience, time.Minute))
+	assert.Error(t, err, "DecryptJWE should reject a token for another audience")
+
+	otherIssuer := valid
+	otherIssuer.Issuer = "someone-else"
+	_, err = kmValidator.DecryptJWE(issue(otherIssuer, time.Minute))
+	assert.Error(t, err, "DecryptJWE should reject a token from another issuer")
+
+	missingSubject := valid
+	missingSubject.Subject = ""
+	_, err = kmValidator.DecryptJWE(issue(missingSubject, time.Minute))
+	assert.Error(t, err, "DecryptJWE should reject a token missing a required claim")
+
+	// A jti is generated when none is given
+	generatedID := valid
+	generatedID.ID = ""
+	_, claims, err = kmValidator.DecryptJWEClaims(issue(generatedID, time.Minute))
+	require.NoError(t, err, "DecryptJWEClaims should not return an error")
+	assert.NotEmpty(t, claims.ID, "Every token should carry a jti")
+
+	// nbf in the future is rejected, unless it is within the leeway
+	notYetValid := valid
+	notYetValid.NotBefore = time.Now().Add(time.Minute)
+	_, err = kmValidator.DecryptJWE(issue(notYetValid, 2*time.Minute))
+	assert.Error(t, err, "DecryptJWE should reject a token that is not valid yet")
+
+	withinLeeway := valid
+	withinLeeway.NotBefore = time.Now().Add(2 * time.Second)
+	_, err = kmValidator.DecryptJWE(issue(withinLeeway, time.Minute))
+	assert.NoError(t, err, "DecryptJWE should accept a nbf within the leeway")
+}
@@ -0,0 +1,152 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/tphan267/common/system"
+)
+
+const (
+	// defaultUnknownKIDRefreshInterval is the default of KeyManagerOptions.UnknownKIDRefreshInterval.
+	defaultUnknownKIDRefreshInterval = 30 * time.Second
+	// minKIDRefreshInterval bounds the on-demand refreshes of all unknown kids,
+	// tokens with a new unknown kid share the refresh of the last second.
+	minKIDRefreshInterval = time.Second
+	// maxTrackedKIDs bounds the number of unknown kids remembered for rate limiting.
+	maxTrackedKIDs = 1024
+)
+
+// kidRefresh is an on-demand refresh triggered by an unknown kid, done is
+// closed once the refresh completed.
+type kidRefresh struct {
+	at   time.Time
+	done chan struct{}
+}
+
+// startKeyRefresh refreshes the keys of validation KeyManagers in the background.
+func (km *KeyManager) startKeyRefresh() {
+	interval := km.options.RefreshInterval
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if err := km.RefreshKeys(); err != nil {
+				system.Logger.Errorf("Error refreshing keys: %v", err)
+			}
+			timer.Reset(interval + rand.N(interval/10+1))
+		case <-km.ctx.Done():
+			system.Logger.Infof("Key refresh goroutine shutting down.")
+			return
+		}
+	}
+}
+
+// RefreshForToken refreshes the keys when the kid of the token is unknown, e.g.
+// when the background refresh has not picked up a rotation yet. Refreshes are
+// rate limited per kid and shared between concurrent callers, so invalid tokens
+// can't flood the store. It reports whether the kid is known after the refresh,
+// in which case verifying the token again is worthwhile.
+func (km *KeyManager) RefreshForToken(token []byte) bool {
+	kid, err := tokenKID(token)
+	if err != nil || kid == "" || km.hasKID(kid) {
+		return false
+	}
+
+	refresh, start := km.kidRefreshFor(kid)
+	if refresh == nil {
+		return false
+	}
+	if start {
+		if err := km.RefreshKeys(); err != nil {
+			system.Logger.Errorf("Error refreshing keys for unknown kid: %v", err)
+		}
+		close(refresh.done)
+	} else {
+		<-refresh.done
+	}
+
+	return km.hasKID(kid)
+}
+
+// kidRefreshFor returns the refresh to wait for before checking kid again, and
+// whether the caller must perform it. It returns nil when too many unknown kids
+// are tracked.
+func (km *KeyManager) kidRefreshFor(kid string) (*kidRefresh, bool) {
+	interval := km.options.UnknownKIDRefreshInterval
+	if interval <= 0 {
+		interval = defaultUnknownKIDRefreshInterval
+	}
+
+	km.kidRefreshMu.Lock()
+	defer km.kidRefreshMu.Unlock()
+
+	now := time.Now()
+	if refresh, ok := km.kidRefreshes[kid]; ok && now.Sub(refresh.at) < interval {
+		return refresh, false
+	}
+
+	if km.kidRefreshes == nil {
+		km.kidRefreshes = map[string]*kidRefresh{}
+	}
+	if len(km.kidRefreshes) >= maxTrackedKIDs {
+		for trackedKID, refresh := range km.kidRefreshes {
+			if now.Sub(refresh.at) >= interval {
+				delete(km.kidRefreshes, trackedKID)
+			}
+		}
+		if len(km.kidRefreshes) >= maxTrackedKIDs {
+			return nil, false
+		}
+	}
+
+	// Share the latest refresh when it is recent enough
+	if last := km.lastKIDRefresh; last != nil && now.Sub(last.at) < minKIDRefreshInterval {
+		km.kidRefreshes[kid] = last
+		return last, false
+	}
+
+	refresh := &kidRefresh{at: now, done: make(chan struct{})}
+	km.kidRefreshes[kid] = refresh
+	km.lastKIDRefresh = refresh
+	return refresh, true
+}
+
+// hasKID reports whether a current or retained key is identified by kid.
+func (km *KeyManager) hasKID(kid string) bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	_, ok := km.keysByKID[kid]
+	return ok
+}
+
+// tokenKID reads the kid from the protected header of a compact JWE or JWS.
+func tokenKID(token []byte) (string, error) {
+	encoded, _, ok := bytes.Cut(token, []byte("."))
+	if !ok {
+		return "", fmt.Errorf("malformed token")
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode token header: %v", err)
+	}
+
+	var header struct {
+		KID string `json:"kid"`
+	}
+	if err := json.Unmarshal(decoded, &header); err != nil {
+		return "", fmt.Errorf("failed to parse token header: %v", err)
+	}
+	return header.KID, nil
+}
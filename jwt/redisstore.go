@@ -0,0 +1,216 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// acquireLockScript takes the lock or extends it when already held by the owner.
+var acquireLockScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then return 1 end
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// releaseLockScript deletes the lock only when held by the owner.
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then redis.call('DEL', KEYS[1]) end
+return 1
+`)
+
+// RedisKeyStore persists key entries in Redis, so issuers and validators can
+// share keys without a SQL database. Entries are JSON encoded in a hash and
+// indexed by expiry in a sorted set.
+type RedisKeyStore struct {
+	redisClient *redis.Client
+	prefix      string
+	keks        []*KeyEncryptionKey
+}
+
+// NewRedisKeyStore initializes a new RedisKeyStore, keys are prefixed with "jwt:keys:".
+// Use database.NewRedisClient or database.RedisClient for the client. Keys are
+// wrapped with the key-encryption-keys like in NewGormKeyStore, so a dump of
+// Redis does not leak them.
+func NewRedisKeyStore(redisClient *redis.Client, keks ...*KeyEncryptionKey) *RedisKeyStore {
+	return &RedisKeyStore{
+		redisClient: redisClient,
+		prefix:      "jwt:keys:",
+		keks:        keks,
+	}
+}
+
+// SaveKey saves a key entry wrapped with the current KEK if any, its ID is
+// assigned from a Redis counter.
+func (s *RedisKeyStore) SaveKey(entry KeyEntry) error {
+	ctx := context.TODO()
+
+	if err := wrapKey(s.keks, &entry); err != nil {
+		return err
+	}
+
+	id, err := s.redisClient.Incr(ctx, s.prefix+"seq").Result()
+	if err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	entry.ID = uint(id)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %v", err)
+	}
+
+	member := strconv.FormatInt(id, 10)
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.prefix+"entries", member, data)
+		pipe.ZAdd(ctx, s.prefix+"expiry", redis.Z{Score: float64(entry.Expiry.UnixMilli()), Member: member})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	return nil
+}
+
+// GetAllKeys retrieves all saved key entries, sorted by expiry ascending, and unwraps their keys.
+func (s *RedisKeyStore) GetAllKeys() ([]KeyEntry, error) {
+	values, err := s.redisClient.HGetAll(context.TODO(), s.prefix+"entries").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve keys: %w", err)
+	}
+
+	keys := make([]KeyEntry, 0, len(values))
+	for _, value := range values {
+		var entry KeyEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode key: %v", err)
+		}
+		if err := unwrapKey(s.keks, &entry); err != nil {
+			return nil, err
+		}
+		keys = append(keys, entry)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Expiry.Before(keys[j].Expiry)
+	})
+	return keys, nil
+}
+
+// PruneKeys deletes the key entries that expire before the given time.
+func (s *RedisKeyStore) PruneKeys(before time.Time) error {
+	ctx := context.TODO()
+
+	ids, err := s.redisClient.ZRangeByScore(ctx, s.prefix+"expiry", &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to prune keys: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	members := make([]any, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, s.prefix+"entries", ids...)
+		pipe.ZRem(ctx, s.prefix+"expiry", members...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune keys: %w", err)
+	}
+	return nil
+}
+
+// RewrapKeys wraps all stored keys with the current KEK, see GormKeyStore.RewrapKeys.
+// Entries are updated with a WATCH transaction, retried when saved or pruned concurrently.
+func (s *RedisKeyStore) RewrapKeys() (int, error) {
+	if len(s.keks) == 0 {
+		return 0, fmt.Errorf("no key-encryption-key configured")
+	}
+
+	ctx := context.TODO()
+	entriesKey := s.prefix + "entries"
+	count := 0
+	rewrap := func(tx *redis.Tx) error {
+		count = 0
+		values, err := tx.HGetAll(ctx, entriesKey).Result()
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]any{}
+		for member, value := range values {
+			var entry KeyEntry
+			if err := json.Unmarshal([]byte(value), &entry); err != nil {
+				return fmt.Errorf("failed to decode key: %v", err)
+			}
+			if !needsRewrap(s.keks, entry) {
+				continue
+			}
+			if err := unwrapKey(s.keks, &entry); err != nil {
+				return err
+			}
+			if err := wrapKey(s.keks, &entry); err != nil {
+				return err
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return fmt.Errorf("failed to encode key: %v", err)
+			}
+			updates[member] = data
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, entriesKey, updates)
+			return nil
+		})
+		count = len(updates)
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := s.redisClient.Watch(ctx, rewrap, entriesKey)
+		if err == redis.TxFailedErr && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to rewrap keys: %v", err)
+		}
+		return count, nil
+	}
+}
+
+// AcquireRotationLock takes the rotation lock with SET NX, see KeyLocker.
+func (s *RedisKeyStore) AcquireRotationLock(owner string, ttl time.Duration) (bool, error) {
+	result, err := acquireLockScript.Run(context.TODO(), s.redisClient,
+		[]string{s.prefix + rotationLockName},
+		owner, ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire rotation lock: %w", err)
+	}
+	return result == 1, nil
+}
+
+// ReleaseRotationLock releases the rotation lock if held by owner, see KeyLocker.
+func (s *RedisKeyStore) ReleaseRotationLock(owner string) error {
+	return releaseLockScript.Run(context.TODO(), s.redisClient, []string{s.prefix + rotationLockName}, owner).Err()
+}
@@ -6,31 +6,60 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"sort"
 	"sync"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // KeyEntry represents an encryption key with associated metadata.
+// Signing keys carry their algorithm and PKIX encoded public key, their Key
+// holds the PKCS#8 encoded private key and is empty on validation-only stores.
 type KeyEntry struct {
-	ID     uint `gorm:"primaryKey"` // For GORM
-	Key    []byte
-	Info   []byte
-	Expiry time.Time
+	ID        uint `gorm:"primaryKey"` // For GORM
+	Key       []byte
+	Info      []byte
+	Expiry    time.Time
+	Alg       string
+	PublicKey []byte
+	// KEKID identifies the KeyEncryptionKey wrapping Key at rest, it is empty
+	// for plaintext keys and for the entries returned by stores.
+	KEKID string `gorm:"column:kek_id;size:16"`
+}
+
+// KID returns the key identifier written to the "kid" header of issued tokens.
+func (e KeyEntry) KID() string {
+	return base64.StdEncoding.EncodeToString(e.Info)
 }
 
 // KeyStore defines methods for persisting and retrieving key entries.
 type KeyStore interface {
 	SaveKey(entry KeyEntry) error
 	GetAllKeys() ([]KeyEntry, error)
+	// PruneKeys deletes the key entries that expire before the given time.
+	PruneKeys(before time.Time) error
+}
+
+// KeyLocker is implemented by stores shared by several issuer replicas, so that
+// only the replica holding the rotation lock generates the next key.
+type KeyLocker interface {
+	// AcquireRotationLock takes the rotation lock for owner until ttl elapses.
+	// It reports false when another owner holds the lock.
+	AcquireRotationLock(owner string, ttl time.Duration) (bool, error)
+	// ReleaseRotationLock releases the rotation lock if held by owner.
+	ReleaseRotationLock(owner string) error
 }
 
 // InMemoryKeyStore is an in-memory implementation of KeyStore.
 type InMemoryKeyStore struct {
 	mu   sync.RWMutex
 	keys []KeyEntry
+
+	lockOwner  string
+	lockExpiry time.Time
 }
 
 // NewInMemoryKeyStore initializes a new in-memory key store.
@@ -58,29 +87,168 @@ func (s *InMemoryKeyStore) GetAllKeys() ([]KeyEntry, error) {
 	return copied, nil
 }
 
+// PruneKeys deletes the key entries that expire before the given time.
+func (s *InMemoryKeyStore) PruneKeys(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = slices.DeleteFunc(s.keys, func(entry KeyEntry) bool {
+		return entry.Expiry.Before(before)
+	})
+	return nil
+}
+
+// AcquireRotationLock takes the rotation lock, see KeyLocker.
+func (s *InMemoryKeyStore) AcquireRotationLock(owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockOwner != "" && s.lockOwner != owner && time.Now().Before(s.lockExpiry) {
+		return false, nil
+	}
+	s.lockOwner = owner
+	s.lockExpiry = time.Now().Add(ttl)
+	return true, nil
+}
+
+// ReleaseRotationLock releases the rotation lock, see KeyLocker.
+func (s *InMemoryKeyStore) ReleaseRotationLock(owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lockOwner == owner {
+		s.lockOwner = ""
+	}
+	return nil
+}
+
+// KeyLock is the row of the rotation lock of GormKeyStore.
+type KeyLock struct {
+	Name      string `gorm:"primaryKey;size:64"`
+	Owner     string `gorm:"size:64"`
+	ExpiresAt time.Time
+}
+
+// rotationLockName is the name of the KeyLock row guarding key rotation.
+const rotationLockName = "rotation"
+
 // GormKeyStore uses GORM to persist key entries.
 type GormKeyStore struct {
-	db *gorm.DB
+	db   *gorm.DB
+	keks []*KeyEncryptionKey
+}
+
+// NewGormKeyStore initializes a new GormKeyStore and migrates the KeyEntry and KeyLock schemas.
+// When key-encryption-keys are given, keys are wrapped with the first one before
+// being saved. The others are previous KEKs, only used to unwrap keys until
+// RewrapKeys has wrapped all stored keys with the current KEK.
+func NewGormKeyStore(db *gorm.DB, keks ...*KeyEncryptionKey) *GormKeyStore {
+	db.AutoMigrate(&KeyEntry{}, &KeyLock{})
+	return &GormKeyStore{db: db, keks: keks}
+}
+
+// AcquireRotationLock takes the rotation lock row, see KeyLocker.
+func (s *GormKeyStore) AcquireRotationLock(owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	// Take over an expired lock or extend our own
+	result := s.db.Model(&KeyLock{}).
+		Where("name = ? AND (expires_at < ? OR owner = ?)", rotationLockName, now, owner).
+		Updates(map[string]any{"owner": owner, "expires_at": expiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// Create the lock row, the insert is ignored when another owner holds it
+	result = s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&KeyLock{
+		Name:      rotationLockName,
+		Owner:     owner,
+		ExpiresAt: expiresAt,
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
 }
 
-// NewGormKeyStore initializes a new GormKeyStore and migrates the KeyEntry schema.
-func NewGormKeyStore(db *gorm.DB) *GormKeyStore {
-	db.AutoMigrate(&KeyEntry{})
-	return &GormKeyStore{db: db}
+// ReleaseRotationLock deletes the rotation lock row, see KeyLocker.
+func (s *GormKeyStore) ReleaseRotationLock(owner string) error {
+	return s.db.Where("name = ? AND owner = ?", rotationLockName, owner).Delete(&KeyLock{}).Error
 }
 
-// SaveKey saves a key entry using GORM.
+// SaveKey saves a key entry using GORM, wrapped with the current KEK if any.
 func (s *GormKeyStore) SaveKey(entry KeyEntry) error {
+	if err := wrapKey(s.keks, &entry); err != nil {
+		return err
+	}
 	return s.db.Create(&entry).Error
 }
 
-// GetAllKeys retrieves all saved key entries using GORM.
+// GetAllKeys retrieves all saved key entries using GORM and unwraps their keys.
 func (s *GormKeyStore) GetAllKeys() ([]KeyEntry, error) {
 	var keys []KeyEntry
-	err := s.db.Order("expiry DESC").Limit(3).Find(&keys).Error
-	return keys, err
+	err := s.db.Order("expiry ASC").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if err := unwrapKey(s.keks, &keys[i]); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// RewrapKeys wraps all stored keys with the current KEK, e.g. after rotating
+// the KEK or enabling encryption on a store holding plaintext keys.
+// It returns the number of re-wrapped keys.
+func (s *GormKeyStore) RewrapKeys() (int, error) {
+	if len(s.keks) == 0 {
+		return 0, fmt.Errorf("no key-encryption-key configured")
+	}
+
+	count := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var keys []KeyEntry
+		if err := tx.Find(&keys).Error; err != nil {
+			return err
+		}
+
+		for _, entry := range keys {
+			if !needsRewrap(s.keks, entry) {
+				continue
+			}
+			if err := unwrapKey(s.keks, &entry); err != nil {
+				return err
+			}
+			if err := wrapKey(s.keks, &entry); err != nil {
+				return err
+			}
+			err := tx.Model(&KeyEntry{}).Where("id = ?", entry.ID).
+				Updates(map[string]any{"key": entry.Key, "kek_id": entry.KEKID}).Error
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to rewrap keys: %v", err)
+	}
+
+	return count, nil
+}
+
+// PruneKeys deletes the key entries that expire before the given time using GORM.
+func (s *GormKeyStore) PruneKeys(before time.Time) error {
+	return s.db.Where("expiry < ?", before).Delete(&KeyEntry{}).Error
 }
 
+// RemoteStore fetches keys in the KeyResponse format of GetCurrentKeysAPIHandler.
+//
+// Deprecated: use JWKSStore with JWKSAPIHandler, which only distributes public keys.
 type RemoteStore struct {
 	remoteURL string
 	apiKey    string
@@ -112,6 +280,11 @@ func (rs *RemoteStore) SaveKey(entry KeyEntry) error {
 	return fmt.Errorf("SaveKey is not supported by RemoteStore")
 }
 
+// PruneKeys is not supported for RemoteStore as it's intended for fetching keys.
+func (rs *RemoteStore) PruneKeys(before time.Time) error {
+	return fmt.Errorf("PruneKeys is not supported by RemoteStore")
+}
+
 // GetAllKeys retrieves all keys from the remote service or from the cache if valid.
 func (rs *RemoteStore) GetAllKeys() ([]KeyEntry, error) {
 	rs.cacheMu.RLock()
@@ -157,23 +330,34 @@ func (rs *RemoteStore) GetAllKeys() ([]KeyEntry, error) {
 	// Convert KeyResponse to KeyEntry
 	var fetchedKeys []KeyEntry
 	for _, kr := range keyResponses {
-		keyBytes, err := base64.StdEncoding.DecodeString(kr.Key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode key: %v", err)
+		entry := KeyEntry{
+			ID:     kr.ID,
+			Expiry: kr.Expiry,
+			Alg:    kr.Alg,
+		}
+
+		if kr.Key != "" {
+			if entry.Key, err = base64.StdEncoding.DecodeString(kr.Key); err != nil {
+				return nil, fmt.Errorf("failed to decode key: %v", err)
+			}
 		}
 
-		infoBytes, err := base64.StdEncoding.DecodeString(kr.Info)
-		if err != nil {
+		if entry.Info, err = base64.StdEncoding.DecodeString(kr.Info); err != nil {
 			return nil, fmt.Errorf("failed to decode info: %v", err)
 		}
 
-		fetchedKeys = append(fetchedKeys, KeyEntry{
-			ID:     kr.ID,
-			Key:    keyBytes,
-			Info:   infoBytes,
-			Expiry: kr.Expiry,
-		})
+		if kr.PublicKey != "" {
+			if entry.PublicKey, err = base64.StdEncoding.DecodeString(kr.PublicKey); err != nil {
+				return nil, fmt.Errorf("failed to decode public key: %v", err)
+			}
+		}
+
+		fetchedKeys = append(fetchedKeys, entry)
 	}
+	// The current key is served first, sort by expiry like the other stores
+	sort.Slice(fetchedKeys, func(i, j int) bool {
+		return fetchedKeys[i].Expiry.Before(fetchedKeys[j].Expiry)
+	})
 
 	// Update cache
 	rs.cacheMu.Lock()
@@ -1,10 +1,21 @@
 package jwt
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
@@ -12,7 +23,13 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tphan267/common/database"
+	"github.com/tphan267/common/system"
 )
 
 func TestInMemoryKeyStore(t *testing.T) {
@@ -109,6 +126,349 @@ func TestGormKeyStore(t *testing.T) {
 	assert.Equal(t, key2.Key, keys[2].Key, "Key with latest expiry should be third")
 }
 
+func TestGormKeyStore_KeyEncryption(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	kek1, err := NewKeyEncryptionKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	kek2, err := NewKeyEncryptionKey(bytes.Repeat([]byte{2}, 32))
+	require.NoError(t, err)
+	assert.NotEqual(t, kek1.ID(), kek2.ID())
+
+	// A key saved before encryption was enabled
+	require.NoError(t, NewGormKeyStore(db).SaveKey(KeyEntry{
+		Key:    []byte("plaintext_key"),
+		Info:   []byte("info1"),
+		Expiry: time.Now().Add(time.Hour),
+	}))
+
+	store := NewGormKeyStore(db, kek1)
+	key := KeyEntry{
+		Key:    []byte("secret_key"),
+		Info:   []byte("info2"),
+		Expiry: time.Now().Add(2 * time.Hour),
+	}
+	require.NoError(t, store.SaveKey(key))
+
+	// The key is wrapped at rest
+	var raw KeyEntry
+	require.NoError(t, db.Where("info = ?", key.Info).First(&raw).Error)
+	assert.Equal(t, kek1.ID(), raw.KEKID)
+	assert.NotContains(t, string(raw.Key), "secret_key")
+
+	keys, err := store.GetAllKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, []byte("plaintext_key"), keys[0].Key)
+	assert.Equal(t, key.Key, keys[1].Key)
+	assert.Empty(t, keys[1].KEKID)
+
+	// Stores without the KEK can't read the wrapped key
+	_, err = NewGormKeyStore(db, kek2).GetAllKeys()
+	assert.Error(t, err, "GetAllKeys should fail with an unknown KEK")
+
+	// Entries without a secret key have nothing to wrap
+	require.NoError(t, NewGormKeyStore(db).SaveKey(KeyEntry{
+		PublicKey: []byte("public_key"),
+		Info:      []byte("info3"),
+		Expiry:    time.Now().Add(3 * time.Hour),
+	}))
+
+	// Rotate the KEK and re-wrap all keys
+	rotated := NewGormKeyStore(db, kek2, kek1)
+	count, err := rotated.RewrapKeys()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "Plaintext and old keys should be re-wrapped")
+
+	keys, err = NewGormKeyStore(db, kek2).GetAllKeys()
+	require.NoError(t, err, "Keys should be readable with the new KEK only")
+	assert.Equal(t, []byte("plaintext_key"), keys[0].Key)
+	assert.Equal(t, key.Key, keys[1].Key)
+
+	count, err = rotated.RewrapKeys()
+	require.NoError(t, err)
+	assert.Zero(t, count, "Keys already wrapped with the current KEK are skipped")
+}
+
+func TestKeyEncryptionKeyFromEnv(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	t.Setenv("JWT_KEK", encoded)
+	kek, err := KeyEncryptionKeyFromEnv()
+	require.NoError(t, err)
+	expected, _ := NewKeyEncryptionKey(key)
+	assert.Equal(t, expected.ID(), kek.ID())
+
+	path := filepath.Join(t.TempDir(), "kek")
+	require.NoError(t, os.WriteFile(path, []byte(encoded+"\n"), 0600))
+	t.Setenv("JWT_KEK", "")
+	t.Setenv("JWT_KEK_FILE", path)
+	kek, err = KeyEncryptionKeyFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, expected.ID(), kek.ID())
+
+	t.Setenv("JWT_KEK", base64.StdEncoding.EncodeToString([]byte("short")))
+	_, err = KeyEncryptionKeyFromEnv()
+	assert.Error(t, err, "KEKs must be 32 bytes")
+}
+
+// testKeyStoreConformance checks the behavior shared by all writable KeyStores.
+func testKeyStoreConformance(t *testing.T, newStore func(t *testing.T) KeyStore) {
+	now := time.Now()
+
+	t.Run("SaveKey and GetAllKeys", func(t *testing.T) {
+		store := newStore(t)
+
+		keys, err := store.GetAllKeys()
+		require.NoError(t, err, "GetAllKeys should not return an error on an empty store")
+		assert.Empty(t, keys)
+
+		saved := []KeyEntry{
+			{Key: []byte("key1"), Info: []byte("info1"), Expiry: now.Add(24 * time.Hour)},
+			{Key: []byte("key2"), Info: []byte("info2"), Expiry: now.Add(48 * time.Hour)},
+			{Key: []byte("key3"), Info: []byte("info3"), Expiry: now.Add(12 * time.Hour), Alg: "ES256", PublicKey: []byte("public3")},
+		}
+		for _, entry := range saved {
+			require.NoError(t, store.SaveKey(entry), "SaveKey should not return an error")
+		}
+
+		keys, err = store.GetAllKeys()
+		require.NoError(t, err, "GetAllKeys should not return an error")
+		require.Len(t, keys, 3, "There should be three keys in the store")
+
+		// Keys should be sorted by Expiry ascending
+		for i, expected := range []KeyEntry{saved[2], saved[0], saved[1]} {
+			assert.Equal(t, expected.Key, keys[i].Key)
+			assert.Equal(t, expected.Info, keys[i].Info)
+			assert.Equal(t, expected.Alg, keys[i].Alg)
+			assert.Equal(t, expected.PublicKey, keys[i].PublicKey)
+			assert.WithinDuration(t, expected.Expiry, keys[i].Expiry, time.Second)
+		}
+	})
+
+	t.Run("PruneKeys", func(t *testing.T) {
+		store := newStore(t)
+		for i := 0; i < 4; i++ {
+			require.NoError(t, store.SaveKey(KeyEntry{
+				Key:    []byte{byte(i)},
+				Info:   []byte("info" + strconv.Itoa(i)),
+				Expiry: now.Add(time.Duration(i-2) * time.Hour),
+			}))
+		}
+
+		require.NoError(t, store.PruneKeys(now), "PruneKeys should not return an error")
+
+		keys, err := store.GetAllKeys()
+		require.NoError(t, err)
+		require.Len(t, keys, 2, "Keys expiring before the given time should be deleted")
+		assert.Equal(t, []byte{2}, keys[0].Key)
+		assert.Equal(t, []byte{3}, keys[1].Key)
+	})
+
+	t.Run("KeyLocker", func(t *testing.T) {
+		locker, ok := newStore(t).(KeyLocker)
+		if !ok {
+			t.Skip("store does not implement KeyLocker")
+		}
+
+		acquired, err := locker.AcquireRotationLock("replica-1", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, acquired, "First replica should acquire the lock")
+
+		acquired, err = locker.AcquireRotationLock("replica-2", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, acquired, "Second replica should not acquire a held lock")
+
+		acquired, err = locker.AcquireRotationLock("replica-1", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, acquired, "Owner should extend its lock")
+
+		require.NoError(t, locker.ReleaseRotationLock("replica-2"), "Releasing a lock held by another owner is a no-op")
+		acquired, err = locker.AcquireRotationLock("replica-2", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, acquired)
+
+		require.NoError(t, locker.ReleaseRotationLock("replica-1"))
+		acquired, err = locker.AcquireRotationLock("replica-2", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, acquired, "Lock should be free after release")
+	})
+}
+
+func TestKeyStoreConformance(t *testing.T) {
+	kek, err := NewKeyEncryptionKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	t.Run("InMemoryKeyStore", func(t *testing.T) {
+		testKeyStoreConformance(t, func(t *testing.T) KeyStore {
+			return NewInMemoryKeyStore()
+		})
+	})
+
+	t.Run("GormKeyStore", func(t *testing.T) {
+		testKeyStoreConformance(t, func(t *testing.T) KeyStore {
+			db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+			require.NoError(t, err)
+			return NewGormKeyStore(db)
+		})
+	})
+
+	t.Run("GormKeyStore with KEK", func(t *testing.T) {
+		testKeyStoreConformance(t, func(t *testing.T) KeyStore {
+			db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+			require.NoError(t, err)
+			return NewGormKeyStore(db, kek)
+		})
+	})
+
+	t.Run("FileKeyStore", func(t *testing.T) {
+		testKeyStoreConformance(t, func(t *testing.T) KeyStore {
+			store, err := NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+			require.NoError(t, err)
+			return store
+		})
+	})
+
+	t.Run("FileKeyStore with KEK", func(t *testing.T) {
+		testKeyStoreConformance(t, func(t *testing.T) KeyStore {
+			store, err := NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"), kek)
+			require.NoError(t, err)
+			return store
+		})
+	})
+
+	t.Run("RedisKeyStore", func(t *testing.T) {
+		redisClient, err := database.NewRedisClient(system.Env("REDIS_CONN", "localhost"))
+		if err != nil {
+			t.Skipf("Redis is not available: %v", err)
+		}
+		defer redisClient.Close()
+
+		for name, keks := range map[string][]*KeyEncryptionKey{"plaintext": nil, "with KEK": {kek}} {
+			t.Run(name, func(t *testing.T) {
+				testKeyStoreConformance(t, func(t *testing.T) KeyStore {
+					store := NewRedisKeyStore(redisClient, keks...)
+					store.prefix = fmt.Sprintf("test:%d:", time.Now().UnixNano())
+					t.Cleanup(func() {
+						keys, _ := redisClient.Keys(context.Background(), store.prefix+"*").Result()
+						if len(keys) > 0 {
+							redisClient.Del(context.Background(), keys...)
+						}
+					})
+					return store
+				})
+			})
+		}
+	})
+}
+
+func TestFileKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	store, err := NewFileKeyStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveKey(KeyEntry{
+		Key:    []byte("file_key"),
+		Info:   []byte("file_info"),
+		Expiry: time.Now().Add(time.Hour),
+	}))
+
+	// The key file is only readable by the owner
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// Another store on the same file, e.g. in another process, shares the keys
+	other, err := NewFileKeyStore(path)
+	require.NoError(t, err)
+	keys, err := other.GetAllKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, []byte("file_key"), keys[0].Key)
+
+	acquired, err := store.AcquireRotationLock("replica-1", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	acquired, err = other.AcquireRotationLock("replica-2", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, acquired, "The rotation lock is shared through the file")
+
+	// Key files accessible by others are rejected
+	require.NoError(t, os.Chmod(path, 0o644))
+	_, err = NewFileKeyStore(path)
+	assert.Error(t, err, "NewFileKeyStore should reject insecure permissions")
+}
+
+func TestFileKeyStore_KeyEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	kek1, err := NewKeyEncryptionKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	kek2, err := NewKeyEncryptionKey(bytes.Repeat([]byte{2}, 32))
+	require.NoError(t, err)
+
+	store, err := NewFileKeyStore(path, kek1)
+	require.NoError(t, err)
+	key := KeyEntry{
+		Key:    []byte("secret_key"),
+		Info:   []byte("info1"),
+		Expiry: time.Now().Add(time.Hour),
+	}
+	require.NoError(t, store.SaveKey(key))
+
+	// The key is wrapped at rest
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), base64.StdEncoding.EncodeToString(key.Key))
+	assert.Contains(t, string(raw), kek1.ID())
+
+	keys, err := store.GetAllKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, key.Key, keys[0].Key)
+	assert.Empty(t, keys[0].KEKID)
+
+	// Stores without the KEK can't read the wrapped key
+	other, err := NewFileKeyStore(path, kek2)
+	require.NoError(t, err)
+	_, err = other.GetAllKeys()
+	assert.Error(t, err, "GetAllKeys should fail with an unknown KEK")
+
+	// Rotate the KEK and re-wrap all keys
+	rotated, err := NewFileKeyStore(path, kek2, kek1)
+	require.NoError(t, err)
+	count, err := rotated.RewrapKeys()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	keys, err = other.GetAllKeys()
+	require.NoError(t, err, "Keys should be readable with the new KEK only")
+	assert.Equal(t, key.Key, keys[0].Key)
+
+	count, err = rotated.RewrapKeys()
+	require.NoError(t, err)
+	assert.Zero(t, count, "Keys already wrapped with the current KEK are skipped")
+}
+
+func TestGormKeyStore_RotationLock(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store := NewGormKeyStore(db)
+
+	acquired, err := store.AcquireRotationLock("replica-1", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// Expired locks are taken over
+	require.NoError(t, db.Model(&KeyLock{}).Where("name = ?", rotationLockName).Update("expires_at", time.Now().Add(-time.Second)).Error)
+	acquired, err = store.AcquireRotationLock("replica-2", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired, "Expired lock should be taken over")
+}
+
 func MockRemoteService(t *testing.T, keys []KeyResponse, statusCode int) *httptest.Server {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(statusCode)
@@ -123,6 +483,46 @@ func MockRemoteService(t *testing.T, keys []KeyResponse, statusCode int) *httpte
 	return server
 }
 
+func TestRemoteStore_EventsAndStatus(t *testing.T) {
+	km, err := NewIssuerKeyManager(time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create KeyManager")
+	defer km.Shutdown()
+	require.NoError(t, km.rotateAndPrune())
+	require.NoError(t, km.rotateAndPrune())
+
+	// Serve the keys in the GetCurrentKeysAPIHandler order, current key first
+	app := fiber.New()
+	app.Get("/keys", km.GetCurrentKeysAPIHandler)
+	resp, err := app.Test(httptest.NewRequest("GET", "/keys", nil))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	validator, err := NewValidationKeyManager(NewRemoteStore(server.URL, "", time.Minute))
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	issuerStatus := km.Status()
+	status := validator.Status()
+	assert.Equal(t, issuerStatus.KID, status.KID, "The current key of the issuer should be current")
+	assert.True(t, issuerStatus.Expiry.Equal(status.Expiry))
+	assert.Equal(t, 2, status.HistorySize)
+
+	var events []KeyEvent
+	validator.Subscribe(func(event KeyEvent) {
+		events = append(events, event)
+	})
+
+	token, err := km.IssueJWE([]byte("payload"), nil)
+	require.NoError(t, err)
+	_, err = validator.DecryptJWE(token)
+	require.NoError(t, err)
+	assert.Empty(t, events, "Tokens of the current key should not report OldKeyUsed")
+}
+
 func TestRemoteStore_GetAllKeys_CacheBehavior(t *testing.T) {
 	// Step 1: Initialize an in-memory key store
 	store := NewInMemoryKeyStore()
@@ -164,23 +564,23 @@ func TestRemoteStore_GetAllKeys_CacheBehavior(t *testing.T) {
 
 	// Define the expected keys:
 	// - Current Key: Key6 (last inserted)
-	// - Two most recent historical keys: Key5 and Key4
+	// - Two most recent historical keys, oldest first: Key4 and Key5
 	expectedKeys := []KeyEntry{
 		{
 			Key:    []byte{6}, // Key6
 			Info:   []byte("info6"),
 			Expiry: now.Add(5 * time.Hour),
 		},
-		{
-			Key:    []byte{5}, // Key5
-			Info:   []byte("info5"),
-			Expiry: now.Add(4 * time.Hour),
-		},
 		{
 			Key:    []byte{4}, // Key4
 			Info:   []byte("info4"),
 			Expiry: now.Add(3 * time.Hour),
 		},
+		{
+			Key:    []byte{5}, // Key5
+			Info:   []byte("info5"),
+			Expiry: now.Add(4 * time.Hour),
+		},
 	}
 
 	// Assert that the returned keys match the expected keys
@@ -301,3 +701,111 @@ func TestRemoteStore_GetAllKeys_CacheBehavior(t *testing.T) {
 //     // Wait for all goroutines to finish
 //     wg.Wait()
 // }
+
+func TestJWKSStore_GetAllKeys(t *testing.T) {
+	// Issue a token with a signing KeyManager
+	kmIssuer, err := NewSigningKeyManager(jwa.ES256(), 1*time.Hour, NewInMemoryKeyStore())
+	require.NoError(t, err, "Failed to create signing KeyManager")
+	defer kmIssuer.Shutdown()
+
+	payload := []byte("jwks payload")
+	token, err := kmIssuer.IssueJWS(payload, nil)
+	require.NoError(t, err, "IssueJWS should not return an error")
+
+	// Publish the keys through the JWKS handler
+	app := fiber.New()
+	app.Get("/.well-known/jwks.json", kmIssuer.JWKSAPIHandler)
+	resp, err := app.Test(httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	require.NoError(t, err, "JWKS request should not return an error")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Cache-Control"), "max-age=")
+	assert.Equal(t, "application/jwk-set+json", resp.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var document struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(body, &document))
+	require.Len(t, document.Keys, 1, "JWKS should contain the current signing key")
+	assert.Equal(t, "ES256", document.Keys[0]["alg"])
+	assert.Equal(t, "sig", document.Keys[0]["use"])
+	assert.NotEmpty(t, document.Keys[0]["kid"])
+	assert.NotContains(t, document.Keys[0], "d", "JWKS must not contain private key material")
+
+	// Serve the document to a JWKSStore and count the fetches
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	store := NewJWKSStore(server.URL, 0)
+	kmValidator, err := NewValidationKeyManager(store)
+	require.NoError(t, err, "Failed to create Validation KeyManager")
+
+	verified, err := kmValidator.VerifyToken(token)
+	assert.NoError(t, err, "VerifyToken should not return an error")
+	assert.Equal(t, payload, verified, "Verified payload should match original payload")
+
+	_, err = store.GetAllKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fetches, "Keys should be served from cache within max-age")
+
+	assert.Error(t, store.SaveKey(KeyEntry{}), "SaveKey should not be supported by JWKSStore")
+}
+
+func TestKeyEntriesFromSet_DefaultAlg(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(t, err)
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		kid       string
+		publicKey any
+		alg       string
+	}{
+		{"rsa", &rsaKey.PublicKey, "RS256"},
+		{"ec-p256", &p256Key.PublicKey, "ES256"},
+		{"ec-p384", &p384Key.PublicKey, "ES384"},
+		{"ec-p521", &p521Key.PublicKey, "ES512"},
+		{"ed25519", edKey, "EdDSA"},
+	}
+
+	set := jwk.NewSet()
+	for _, tt := range tests {
+		key, err := jwk.Import(tt.publicKey)
+		require.NoError(t, err)
+		key.Set(jwk.KeyIDKey, tt.kid)
+		require.NoError(t, set.AddKey(key))
+	}
+
+	// An explicit alg is kept
+	key, err := jwk.Import(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	key.Set(jwk.KeyIDKey, "rsa-ps256")
+	key.Set(jwk.AlgorithmKey, jwa.PS256())
+	require.NoError(t, set.AddKey(key))
+
+	entries, err := keyEntriesFromSet(set)
+	require.NoError(t, err)
+	algs := map[string]string{}
+	for _, entry := range entries {
+		algs[string(entry.Info)] = entry.Alg
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.alg, algs[tt.kid], "Keys without alg should default by key type: %s", tt.kid)
+	}
+	assert.Equal(t, "PS256", algs["rsa-ps256"])
+}
@@ -1,17 +1,240 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
-// Encode Generate return a hashed password
-func HashPassword(raw string) string {
-	hash, err := bcrypt.GenerateFromPassword([]byte(raw), 10)
+	"github.com/tphan267/common/system"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrPasswordMismatch is returned when the password doesn't match the hash.
+	// It is the bcrypt error, for callers comparing with it.
+	ErrPasswordMismatch = bcrypt.ErrMismatchedHashAndPassword
+	// ErrUnknownPasswordHash is returned for hashes of no supported algorithm.
+	ErrUnknownPasswordHash = errors.New("unknown password hash format")
+)
+
+// PasswordHasher hashes passwords, the algorithm and its parameters are encoded
+// in the hash string.
+type PasswordHasher interface {
+	Hash(password []byte) (string, error)
+	// Verify compares the password with the hash, needsRehash reports that the
+	// hash uses other parameters than the hasher.
+	Verify(hash string, password []byte) (needsRehash bool, err error)
+	// Supports reports whether the hash was produced by the algorithm of the hasher.
+	Supports(hash string) bool
+}
+
+var (
+	passwordHasher PasswordHasher = NewArgon2idHasher()
+	// passwordHashers verify the hashes of other algorithms than passwordHasher.
+	passwordHashers = []PasswordHasher{NewArgon2idHasher(), NewBcryptHasher()}
+)
+
+// SetPasswordHasher sets the hasher used by HashPassword. Hashes of other
+// algorithms or parameters are still verified, and reported as needing a rehash.
+func SetPasswordHasher(hasher PasswordHasher) {
+	passwordHasher = hasher
+}
+
+// HashPassword hashes the password with the hasher set by SetPasswordHasher,
+// argon2id by default. The password is peppered when PASSWORD_PEPPER is set.
+func HashPassword(raw string) (string, error) {
+	return passwordHasher.Hash(pepperPassword(raw))
+}
+
+// VerifyPassword compares a hashed password with plaintext password, it
+// returns ErrPasswordMismatch when they don't match.
+func VerifyPassword(hash string, raw string) error {
+	_, err := VerifyPasswordRehash(hash, raw)
+	return err
+}
+
+// VerifyPasswordRehash compares a hashed password with plaintext password and
+// reports whether the hash is outdated, i.e. uses another algorithm or other
+// parameters than HashPassword, or lacks the pepper. Login flows should then
+// store a new hash of the password.
+func VerifyPasswordRehash(hash string, raw string) (bool, error) {
+	hasher := passwordHasher
+	if !hasher.Supports(hash) {
+		hasher = nil
+		for _, h := range passwordHashers {
+			if h.Supports(hash) {
+				hasher = h
+				break
+			}
+		}
+		if hasher == nil {
+			return false, ErrUnknownPasswordHash
+		}
+	}
+
+	needsRehash, err := hasher.Verify(hash, pepperPassword(raw))
+	if errors.Is(err, ErrPasswordMismatch) && passwordPepper() != "" {
+		// Hashes stored before the pepper was configured
+		if _, legacyErr := hasher.Verify(hash, []byte(raw)); legacyErr == nil {
+			return true, nil
+		}
+	}
 	if err != nil {
-		panic(err)
+		return false, err
 	}
-	return string(hash)
+
+	return needsRehash || hasher != passwordHasher, nil
 }
 
-// Verify compares a hashed password with plaintext password
-func VerifyPassword(hash string, raw string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(raw))
+func passwordPepper() string {
+	return system.Env("PASSWORD_PEPPER")
+}
+
+// pepperPassword returns the HMAC of the password keyed by the pepper, base64
+// encoded so it fits the 72 bytes limit of bcrypt and contains no NUL byte.
+func pepperPassword(raw string) []byte {
+	pepper := passwordPepper()
+	if pepper == "" {
+		return []byte(raw)
+	}
+
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(raw))
+	return []byte(base64.RawStdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// Limits of the argon2id hashes accepted by Verify, hashes outside of them are
+// rejected before computing the key.
+const (
+	argon2MaxMemory = 4 * 1024 * 1024 // KiB
+	argon2MinSalt   = 16
+	argon2MinKey    = 16
+)
+
+// Argon2idHasher hashes passwords with argon2id, hashes use the PHC string
+// format "$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>".
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// NewArgon2idHasher returns an Argon2idHasher with the parameters recommended
+// by RFC 9106 for memory constrained environments: 3 passes over 64 MiB.
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 2,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password []byte) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey(password, salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(hash string, password []byte) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	var memory, passes uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &passes, &threads); err != nil {
+		return false, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	if passes == 0 || threads == 0 || memory < 8*uint32(threads) || memory > argon2MaxMemory {
+		return false, fmt.Errorf("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if len(salt) < argon2MinSalt {
+		return false, fmt.Errorf("invalid argon2id salt: shorter than %d bytes", argon2MinSalt)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	if len(key) < argon2MinKey {
+		return false, fmt.Errorf("invalid argon2id key: shorter than %d bytes", argon2MinKey)
+	}
+
+	computed := argon2.IDKey(password, salt, passes, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, ErrPasswordMismatch
+	}
+
+	needsRehash := memory != h.Memory || passes != h.Time || threads != h.Threads ||
+		uint32(len(key)) != h.KeyLen || uint32(len(salt)) != h.SaltLen
+	return needsRehash, nil
+}
+
+func (h *Argon2idHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// BcryptHasher hashes passwords with bcrypt, e.g. for hashes created before argon2id.
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher returns a BcryptHasher with the cost used by earlier HashPassword versions.
+func NewBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: 10}
+}
+
+func (h *BcryptHasher) Hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash string, password []byte) (bool, error) {
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), password); err != nil {
+		return false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, err
+	}
+	return cost < h.Cost, nil
+}
+
+func (h *BcryptHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
 }
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idHasher returns an Argon2idHasher cheap enough for tests.
+func testArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    1,
+		Memory:  1024,
+		Threads: 1,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+// useHasher sets the hasher of HashPassword for the test.
+func useHasher(t *testing.T, hasher PasswordHasher) {
+	previous := passwordHasher
+	SetPasswordHasher(hasher)
+	t.Cleanup(func() { SetPasswordHasher(previous) })
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := testArgon2idHasher()
+
+	hash, err := hasher.Hash([]byte("secret"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+	assert.True(t, hasher.Supports(hash))
+
+	needsRehash, err := hasher.Verify(hash, []byte("secret"))
+	require.NoError(t, err)
+	assert.False(t, needsRehash)
+
+	_, err = hasher.Verify(hash, []byte("wrong"))
+	assert.ErrorIs(t, err, ErrPasswordMismatch)
+
+	other, err := hasher.Hash([]byte("secret"))
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "Hashes should be salted")
+}
+
+func TestArgon2idHasher_ParseErrors(t *testing.T) {
+	hasher := testArgon2idHasher()
+	hash, err := hasher.Hash([]byte("secret"))
+	require.NoError(t, err)
+	parts := strings.Split(hash, "$")
+
+	tests := map[string]string{
+		"missing key":     strings.Join(parts[:5], "$"),
+		"other algorithm": strings.Replace(hash, "$argon2id$", "$argon2i$", 1),
+		"bad version":     strings.Replace(hash, "v=19", "v=x", 1),
+		"other version":   strings.Replace(hash, "v=19", "v=16", 1),
+		"bad parameters":  strings.Replace(hash, "m=1024,t=1,p=1", "m=1024;t=1", 1),
+		"zero passes":     strings.Replace(hash, "t=1", "t=0", 1),
+		"zero threads":    strings.Replace(hash, "p=1", "p=0", 1),
+		"bad salt":        strings.Replace(hash, parts[4], "!!", 1),
+		"bad key":         strings.Replace(hash, parts[5], "!!", 1),
+		"empty key":       strings.Join(parts[:5], "$") + "$",
+		"short key":       strings.Replace(hash, parts[5], "c2hvcnRrZXk", 1),
+		"short salt":      strings.Replace(hash, parts[4], "c2FsdA", 1),
+		"memory too low":  strings.Replace(hash, "m=1024,t=1,p=1", "m=8,t=1,p=2", 1),
+		"memory too high": strings.Replace(hash, "m=1024", "m=4294967295", 1),
+	}
+
+	for name, hash := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := hasher.Verify(hash, []byte("secret"))
+			assert.Error(t, err)
+			assert.NotErrorIs(t, err, ErrPasswordMismatch)
+		})
+	}
+}
+
+func TestArgon2idHasher_NeedsRehash(t *testing.T) {
+	hash, err := testArgon2idHasher().Hash([]byte("secret"))
+	require.NoError(t, err)
+
+	tests := map[string]func(h *Argon2idHasher){
+		"time":    func(h *Argon2idHasher) { h.Time = 2 },
+		"memory":  func(h *Argon2idHasher) { h.Memory = 2048 },
+		"threads": func(h *Argon2idHasher) { h.Threads = 2 },
+		"key":     func(h *Argon2idHasher) { h.KeyLen = 64 },
+		"salt":    func(h *Argon2idHasher) { h.SaltLen = 32 },
+	}
+
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			hasher := testArgon2idHasher()
+			change(hasher)
+			needsRehash, err := hasher.Verify(hash, []byte("secret"))
+			require.NoError(t, err, "Hashes with other parameters should still verify")
+			assert.True(t, needsRehash)
+		})
+	}
+}
+
+func TestBcryptHasher_NeedsRehash(t *testing.T) {
+	hash, err := (&BcryptHasher{Cost: bcrypt.MinCost}).Hash([]byte("secret"))
+	require.NoError(t, err)
+
+	hasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	assert.True(t, hasher.Supports(hash))
+	needsRehash, err := hasher.Verify(hash, []byte("secret"))
+	require.NoError(t, err)
+	assert.False(t, needsRehash)
+
+	hasher.Cost = bcrypt.MinCost + 1
+	needsRehash, err = hasher.Verify(hash, []byte("secret"))
+	require.NoError(t, err)
+	assert.True(t, needsRehash, "Hashes with a lower cost should be rehashed")
+
+	_, err = hasher.Verify(hash, []byte("wrong"))
+	assert.ErrorIs(t, err, ErrPasswordMismatch)
+}
+
+func TestHashPassword(t *testing.T) {
+	t.Setenv("PASSWORD_PEPPER", "")
+	useHasher(t, testArgon2idHasher())
+
+	hash, err := HashPassword("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$"))
+
+	assert.NoError(t, VerifyPassword(hash, "secret"))
+	assert.ErrorIs(t, VerifyPassword(hash, "wrong"), ErrPasswordMismatch)
+	assert.ErrorIs(t, VerifyPassword("$unknown$hash", "secret"), ErrUnknownPasswordHash)
+}
+
+func TestVerifyPasswordRehash_BcryptMigration(t *testing.T) {
+	t.Setenv("PASSWORD_PEPPER", "")
+
+	// A hash stored by the earlier bcrypt HashPassword
+	useHasher(t, &BcryptHasher{Cost: bcrypt.MinCost})
+	legacy, err := HashPassword("secret")
+	require.NoError(t, err)
+
+	useHasher(t, testArgon2idHasher())
+	needsRehash, err := VerifyPasswordRehash(legacy, "secret")
+	require.NoError(t, err, "bcrypt hashes should still verify")
+	assert.True(t, needsRehash, "bcrypt hashes should be migrated to argon2id")
+
+	_, err = VerifyPasswordRehash(legacy, "wrong")
+	assert.ErrorIs(t, err, ErrPasswordMismatch)
+
+	migrated, err := HashPassword("secret")
+	require.NoError(t, err)
+	needsRehash, err = VerifyPasswordRehash(migrated, "secret")
+	require.NoError(t, err)
+	assert.False(t, needsRehash)
+}
+
+func TestVerifyPasswordRehash_Pepper(t *testing.T) {
+	useHasher(t, testArgon2idHasher())
+
+	// A hash stored before the pepper was configured
+	t.Setenv("PASSWORD_PEPPER", "")
+	legacy, err := HashPassword("secret")
+	require.NoError(t, err)
+
+	t.Setenv("PASSWORD_PEPPER", "pepper")
+	needsRehash, err := VerifyPasswordRehash(legacy, "secret")
+	require.NoError(t, err, "Hashes without the pepper should still verify")
+	assert.True(t, needsRehash, "Hashes without the pepper should be rehashed")
+
+	_, err = VerifyPasswordRehash(legacy, "wrong")
+	assert.ErrorIs(t, err, ErrPasswordMismatch)
+
+	peppered, err := HashPassword("secret")
+	require.NoError(t, err)
+	needsRehash, err = VerifyPasswordRehash(peppered, "secret")
+	require.NoError(t, err)
+	assert.False(t, needsRehash)
+
+	// The pepper is required to verify peppered hashes
+	t.Setenv("PASSWORD_PEPPER", "other")
+	_, err = VerifyPasswordRehash(peppered, "secret")
+	assert.ErrorIs(t, err, ErrPasswordMismatch)
+}
+
+func TestPepperPassword_LongPasswords(t *testing.T) {
+	t.Setenv("PASSWORD_PEPPER", "pepper")
+	useHasher(t, &BcryptHasher{Cost: bcrypt.MinCost})
+
+	// bcrypt rejects passwords over 72 bytes, peppered passwords are shorter
+	long := strings.Repeat("a", 100)
+	hash, err := HashPassword(long)
+	require.NoError(t, err)
+	assert.NoError(t, VerifyPassword(hash, long))
+	assert.ErrorIs(t, VerifyPassword(hash, long+"b"), ErrPasswordMismatch)
+	assert.LessOrEqual(t, len(pepperPassword(long)), 72)
+}